@@ -0,0 +1,126 @@
+package natsws
+
+import (
+	"encoding/json"
+	"github.com/maxence-charriere/go-app/v9/pkg/app"
+	"github.com/nats-io/nats.go"
+)
+
+// ActionHeader holds the name of a go-app action forwarded by an ActionBridge.
+const ActionHeader = "Natsws-Action"
+
+// ActionOriginHeader identifies the client and tab of the ActionBridge that forwarded an action.
+const ActionOriginHeader = "Natsws-Origin"
+
+// ActionOriginTag is set to ActionOriginNats on actions injected by an ActionBridge.
+const ActionOriginTag = "natsws-origin"
+
+const ActionOriginNats = "nats"
+
+// ActionBridge forwards go-app actions to nats subjects and injects nats messages as actions,
+// so components handle remote events with ctx.Handle like local ones.
+//
+// Actions that arrived from nats are not forwarded again, and messages forwarded by the
+// bridge itself are not injected back.
+type ActionBridge struct {
+	// OnError is called on the UI goroutine when forwarding an action fails.
+	OnError func(ctx app.Context, action app.Action, err error)
+
+	ctx  app.Context
+	conn *Connection
+}
+
+// BridgeActions creates an ActionBridge for the component of ctx.
+// Pass the observed Connection of the component, it must not be nil.
+func BridgeActions(ctx app.Context, conn *Connection) *ActionBridge {
+	return &ActionBridge{ctx: ctx, conn: conn}
+}
+
+// origin identifies the bridge in forwarded messages. The client name and tab id are
+// assigned after the Connection starts, they are read from the running Connection.
+func (b *ActionBridge) origin() string {
+	conn := b.conn
+	if conn.self != nil {
+		conn = conn.self
+	}
+	return conn.ClientName() + " tab " + conn.TabID()
+}
+
+// Forward publishes the actions named name to subject while the component is mounted.
+//
+// The value of the action is encoded as JSON and its tags are sent as headers.
+func (b *ActionBridge) Forward(name, subject string) {
+	b.ctx.Handle(name, func(ctx app.Context, action app.Action) {
+		if action.Tags.Get(ActionOriginTag) == ActionOriginNats {
+			return
+		}
+		if err := b.forward(subject, action); err != nil && b.OnError != nil {
+			b.OnError(ctx, action, err)
+		}
+	})
+}
+
+func (b *ActionBridge) forward(subject string, action app.Action) (err error) {
+	var data []byte
+	if data, err = json.Marshal(action.Value); err != nil {
+		return
+	}
+	msg := NewMsg(subject, data, ContentTypeHeader, "application/json",
+		ActionHeader, action.Name, ActionOriginHeader, b.origin())
+	for key, value := range action.Tags {
+		msg.Header.Set(key, value)
+	}
+	return b.conn.PublishMsg(msg)
+}
+
+// Inject subscribes to subject and creates an action for each message. The action is named name,
+// or the ActionHeader of the message when name is empty. Headers of the message become tags and
+// the value is the json.RawMessage of the message data, decode it with ActionValue.
+//
+// Like other subscriptions Inject needs a connected Connection, the subscription lasts as long
+// as the Connection. Call unsubscribe in OnDismount of the component.
+func (b *ActionBridge) Inject(subject, name string) (unsubscribe func(), err error) {
+	var sub *subscription
+	sub, err = b.conn.subscribe(subject, "", func(msg *nats.Msg) {
+		if HeaderValue(msg, ActionOriginHeader) == b.origin() {
+			return
+		}
+		actionName := name
+		if actionName == "" {
+			actionName = HeaderValue(msg, ActionHeader)
+		}
+		if actionName == "" {
+			return
+		}
+
+		tags := app.Tags{}
+		for key, values := range msg.Header {
+			if len(values) > 0 {
+				tags.Set(key, values[0])
+			}
+		}
+		tags.Set(ActionOriginTag, ActionOriginNats)
+		b.ctx.NewActionWithValue(actionName, json.RawMessage(msg.Data), tags)
+	})
+	if err != nil {
+		return
+	}
+	return func() { _ = b.conn.removeSubscription(sub) }, nil
+}
+
+// ActionValue returns the value of action as T. Values of actions injected by an ActionBridge
+// are decoded from JSON, values of local actions are returned when they are a T.
+func ActionValue[T any](action app.Action) (value T, err error) {
+	switch v := action.Value.(type) {
+	case T:
+		return v, nil
+	case json.RawMessage:
+		err = json.Unmarshal(v, &value)
+	default:
+		var data []byte
+		if data, err = json.Marshal(v); err == nil {
+			err = json.Unmarshal(data, &value)
+		}
+	}
+	return
+}
@@ -0,0 +1,80 @@
+//go:build !wasm
+
+package natsws
+
+import (
+	"github.com/maxence-charriere/go-app/v9/pkg/app"
+	"github.com/nats-io/nats.go"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestActionBridgeNoRebroadcast(t *testing.T) {
+	// other is another tab of the client
+	tc := newTestConnection(t)
+	other, ctx := tc.Connection, tc.ctx
+
+	remote, _ := tc.backend(t)
+	var published int32
+	if _, err := remote.Subscribe("actions.moved", func(msg *nats.Msg) { atomic.AddInt32(&published, 1) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := tc.dialer.Dial(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	compo := &testCompo{}
+	dispatcher := app.NewClientTester(compo)
+	defer dispatcher.Close()
+
+	// the bridge is created before the Connection assigned its tab id
+	bridge := BridgeActions(compo.ctx, conn)
+	if err = conn.WaitConnected(ctx); err != nil {
+		t.Fatal(err)
+	}
+	bridge.Forward("moved", "actions.moved")
+	unsubscribe, err := bridge.Inject("actions.moved", "moved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+
+	var local, injected int
+	compo.ctx.Handle("moved", func(ctx app.Context, action app.Action) {
+		if action.Tags.Get(ActionOriginTag) == ActionOriginNats {
+			injected++
+		} else {
+			local++
+		}
+	})
+
+	// a local action is forwarded once and not injected back into the tab that forwarded it
+	compo.ctx.NewActionWithValue("moved", map[string]int{"X": 1})
+	waitFor(t, ctx, "forward", func() bool {
+		dispatcher.Consume()
+		return atomic.LoadInt32(&published) == 1
+	})
+
+	// an action from another tab of the same client is injected and not forwarded again
+	otherBridge := BridgeActions(compo.ctx, other)
+	if err = otherBridge.forward("actions.moved", app.Action{Name: "moved", Value: map[string]int{"X": 2}}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, ctx, "inject", func() bool {
+		dispatcher.Consume()
+		return injected == 1
+	})
+
+	time.Sleep(100 * time.Millisecond)
+	dispatcher.Consume()
+	if local != 1 || injected != 1 || atomic.LoadInt32(&published) != 2 {
+		t.Fatalf("expected 1 local, 1 injected and 2 published actions, got %d %d %d", local, injected, published)
+	}
+}
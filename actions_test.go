@@ -0,0 +1,21 @@
+package natsws
+
+import (
+	"encoding/json"
+	"github.com/maxence-charriere/go-app/v9/pkg/app"
+	"testing"
+)
+
+func TestActionValue(t *testing.T) {
+	type point struct{ X, Y int }
+
+	local := app.Action{Name: "moved", Value: point{1, 2}}
+	remote := app.Action{Name: "moved", Value: json.RawMessage(`{"X":3,"Y":4}`)}
+	converted := app.Action{Name: "moved", Value: map[string]int{"X": 5, "Y": 6}}
+
+	for action, expected := range map[*app.Action]point{&local: {1, 2}, &remote: {3, 4}, &converted: {5, 6}} {
+		if value, err := ActionValue[point](*action); err != nil || value != expected {
+			t.Fatalf("expected %v, got %v %v", expected, value, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package natsws
+
+import (
+	"context"
+	"github.com/maxence-charriere/go-app/v9/pkg/app"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// binding connects a Connection to the environment it runs in. The Connection itself only
+// publishes, subscribes, requests and tracks its state, the binding provides its lifetime,
+// where observers are notified and where client names, cursors and the outbox are stored.
+//
+// Component binds a Connection to go-app in the browser, Dialer binds it to a plain Go program.
+type binding interface {
+	// context ends the Connection when done.
+	context() context.Context
+	// notify lets observers know that the state changed, c is a copy that does not change.
+	notify(c *Connection)
+	// setState stores value under key, persist keeps it across page reloads.
+	setState(key string, value any, persist bool)
+	getState(key string, value any)
+	// setSession stores value under key for the lifetime of the browser tab.
+	setSession(key string, value any)
+	getSession(key string, value any)
+	getenv(key string) string
+	// windowUrl is the location the default Proxy url is derived from.
+	windowUrl() *url.URL
+}
+
+var _ binding = appBinding{}
+
+// appBinding binds a Connection to a go-app Context.
+type appBinding struct {
+	ctx app.Context
+}
+
+func (b appBinding) context() context.Context {
+	return b.ctx
+}
+
+func (b appBinding) notify(c *Connection) {
+	b.ctx.SetState(StateKey(c.name), c)
+}
+
+func (b appBinding) setState(key string, value any, persist bool) {
+	if persist {
+		b.ctx.SetState(key, value, app.Persist)
+		return
+	}
+	b.ctx.SetState(key, value)
+}
+
+func (b appBinding) getState(key string, value any) {
+	b.ctx.GetState(key, value)
+}
+
+func (b appBinding) setSession(key string, value any) {
+	_ = b.ctx.SessionStorage().Set(key, value)
+}
+
+func (b appBinding) getSession(key string, value any) {
+	_ = b.ctx.SessionStorage().Get(key, value)
+}
+
+func (b appBinding) getenv(key string) string {
+	return app.Getenv(key)
+}
+
+func (b appBinding) windowUrl() *url.URL {
+	return app.Window().URL()
+}
+
+// newConnection creates a Connection with the state shared by its copies, start it with run.
+func newConnection(b binding, name string) *Connection {
+	traffic := &traffic{}
+	c := &Connection{
+		binding: b, name: name, subs: &subscriptions{traffic: traffic}, traffic: traffic,
+		mu: &sync.Mutex{}, control: make(chan func()), done: make(chan struct{}),
+		latency: &latency{}, stats: &connectionStats{started: time.Now()},
+		outbox: &outbox{handlers: map[string]DeliveryHandler{}},
+	}
+	c.self = c
+	return c
+}
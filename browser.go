@@ -0,0 +1,145 @@
+package natsws
+
+import (
+	"github.com/maxence-charriere/go-app/v9/pkg/app"
+	"time"
+)
+
+const Online ChangeReason = "online"
+const Offline ChangeReason = "offline"
+const Hidden ChangeReason = "hidden"
+const Visible ChangeReason = "visible"
+const Suspended ChangeReason = "suspended"
+
+// watchBrowser tracks the browser online and visibility state through window events
+// and returns a function that removes the event listeners.
+func (c *Connection) watchBrowser() (release func()) {
+	unlock := c.lock()
+	defer unlock()
+	c.online = true
+	if !app.IsClient {
+		return func() {}
+	}
+
+	c.online = app.Window().Get("navigator").Get("onLine").Bool()
+	c.hidden = app.Window().Get("document").Get("hidden").Bool()
+
+	var releases []func()
+	listen := func(event string, fn func()) {
+		releases = append(releases, app.Window().AddEventListener(event, func(ctx app.Context, e app.Event) {
+			c.do(fn)
+		}))
+	}
+	listen("online", c.onOnline)
+	listen("offline", c.onOffline)
+	// visibilitychange is fired on the document and bubbles up to the window
+	listen("visibilitychange", c.onVisibilityChange)
+	if !c.options.DisableDrainOnUnload {
+		// go-app handles events after they return, pagehide is handled while the page is still there
+		onPageHide := app.FuncOf(func(this app.Value, args []app.Value) any {
+			c.onPageHide()
+			return nil
+		})
+		app.Window().Call("addEventListener", "pagehide", onPageHide)
+		releases = append(releases, func() {
+			app.Window().Call("removeEventListener", "pagehide", onPageHide)
+			onPageHide.Release()
+		})
+		releases = append(releases, app.Window().AddEventListener("pageshow", func(ctx app.Context, e app.Event) {
+			c.onPageShow(e.Value)
+		}))
+	}
+
+	return func() {
+		for _, r := range releases {
+			r()
+		}
+		unlock := c.lock()
+		defer unlock()
+		if c.suspendTimer != nil {
+			c.suspendTimer.Stop()
+		}
+	}
+}
+
+func (c *Connection) onOnline() {
+	c.update(func() bool {
+		c.online = true
+		c.changeReason = Online
+		return true
+	})
+	if !c.IsConnected() && !c.IsSuspended() {
+		// skip the remaining reconnect wait
+		c.reconnect()
+	}
+}
+
+func (c *Connection) onOffline() {
+	c.update(func() bool {
+		c.online = false
+		c.changeReason = Offline
+		return true
+	})
+}
+
+func (c *Connection) onVisibilityChange() {
+	hidden := app.Window().Get("document").Get("hidden").Bool()
+	c.update(func() bool {
+		c.hidden = hidden
+		if c.suspendTimer != nil {
+			c.suspendTimer.Stop()
+			c.suspendTimer = nil
+		}
+		if !hidden {
+			c.changeReason = Visible
+			return true
+		}
+		c.changeReason = Hidden
+		if grace := c.options.SuspendWhenHidden; grace > 0 {
+			c.suspendTimer = time.AfterFunc(grace, func() { c.do(c.suspend) })
+		}
+		return true
+	})
+
+	if !hidden && (c.IsSuspended() || !c.IsConnected()) {
+		c.reconnect()
+	}
+}
+
+// suspend closes the nats connection of a hidden tab until it becomes visible again.
+// A leader tab stays connected while other tabs relay through it and checks again later.
+func (c *Connection) suspend() {
+	if c.closed || !c.IsHidden() || c.IsSuspended() {
+		return
+	}
+	if c.relay.hasFollowers() {
+		unlock := c.lock()
+		c.suspendTimer = time.AfterFunc(c.options.SuspendWhenHidden, func() { c.do(c.suspend) })
+		unlock()
+		return
+	}
+	c.closeNats(true)
+	c.update(func() bool {
+		c.suspended = true
+		c.changeReason = Suspended
+		return true
+	})
+}
+
+// IsOnline reports the navigator.onLine state of the browser.
+func (c *Connection) IsOnline() bool {
+	defer c.lock()()
+	return c.online
+}
+
+// IsHidden reports if the browser tab is hidden.
+func (c *Connection) IsHidden() bool {
+	defer c.lock()()
+	return c.hidden
+}
+
+// IsSuspended reports if the connection was closed because the tab was hidden.
+func (c *Connection) IsSuspended() bool {
+	defer c.lock()()
+	return c.suspended
+}
@@ -0,0 +1,129 @@
+package natsws
+
+import (
+	"github.com/maxence-charriere/go-app/v9/pkg/app"
+	"github.com/nats-io/nats.go"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// closeFlushTimeout bounds the flush of pending publishes by Close.
+const closeFlushTimeout = time.Second
+
+// Close flushes pending publishes, removes the subscriptions and closes the websocket with a
+// normal closure. Observers receive ChangeReason Closed and the Connection does not reconnect.
+//
+// Close can be called on the copy of the Connection an observer receives.
+func (c *Connection) Close() error {
+	return c.stop(false, closeFlushTimeout)
+}
+
+// Drain is like Close but first lets the subscriptions handle the messages already received,
+// it waits up to timeout before closing. Drain returns nats.ErrDrainTimeout when the drain did
+// not complete in time. Call it from ctx.Async, it blocks until the Connection is closed.
+func (c *Connection) Drain(timeout time.Duration) error {
+	return c.stop(true, timeout)
+}
+
+// stop closes the Connection on the run goroutine and waits until it is done.
+func (c *Connection) stop(drain bool, timeout time.Duration) (err error) {
+	if c.control == nil {
+		return nats.ErrConnectionClosed
+	}
+	result := make(chan error, 1)
+	c.do(func() { result <- c.self.close(drain, timeout) })
+	select {
+	case err = <-result:
+	case <-c.done:
+		select {
+		case err = <-result:
+		default:
+			err = nats.ErrConnectionClosed
+		}
+	case <-c.ctx().Done():
+		err = c.ctx().Err()
+	}
+	return
+}
+
+func (c *Connection) close(drain bool, timeout time.Duration) (err error) {
+	if c.closed {
+		return nats.ErrConnectionClosed
+	}
+	unlock := c.lock()
+	c.closed = true
+	// the closing nats connection must not report Disconnect or Error to observers
+	atomic.AddUint64(&c.generation, 1)
+	conn := c.natsConn
+	unlock()
+
+	if conn != nil {
+		if drain {
+			err = c.drainConn(conn, timeout)
+		} else {
+			err = conn.FlushTimeout(timeout)
+		}
+	}
+	c.subs.unsubscribe()
+	c.closeNats(false)
+
+	c.setReason(Closed)
+	return
+}
+
+// drainConn drains conn and waits up to timeout for the drain to close it. The run goroutine
+// keeps handling control functions meanwhile, they do not connect again once closed is set.
+func (c *Connection) drainConn(conn *nats.Conn, timeout time.Duration) (err error) {
+	// the generation changed, the ClosedHandler of connect ignores conn already
+	drained := make(chan struct{})
+	conn.SetClosedHandler(func(*nats.Conn) { close(drained) })
+	if err = conn.Drain(); err != nil {
+		return
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-drained:
+			return
+		case <-timer.C:
+			return nats.ErrDrainTimeout
+		case fn := <-c.control:
+			fn()
+		case <-c.ctx().Done():
+			return c.ctx().Err()
+		}
+	}
+}
+
+// onPageHide closes the nats connection on a best effort basis when the page is hidden for a
+// navigation. It runs within the pagehide event because the browser does not wait for work
+// started later: nats.Conn.Close writes the buffered publishes and the websocket close frame
+// before it waits for the close event. The page may return from the back/forward cache,
+// so the Connection is suspended rather than closed until the page is gone.
+func (c *Connection) onPageHide() {
+	c.subs.persist()
+	c.relay.hide()
+	c.update(func() bool {
+		c.suspended = true
+		c.changeReason = Suspended
+		return true
+	})
+	go c.closeNats(true)
+	// the event handler cannot wait on the event loop, yield so that Close writes first
+	runtime.Gosched()
+}
+
+// onPageShow resumes a Connection restored from the back/forward cache.
+func (c *Connection) onPageShow(e app.Value) {
+	if !e.Get("persisted").Bool() {
+		return
+	}
+	c.do(func() {
+		c.relay.show()
+		if c.IsSuspended() {
+			c.reconnect()
+		}
+	})
+}
@@ -2,17 +2,40 @@ package natsws
 
 import (
 	"github.com/maxence-charriere/go-app/v9/pkg/app"
+	"github.com/nats-io/nats.go"
+	"time"
 )
 
 var _ app.Mounter = (*Component)(nil)
 
 type Component struct {
 	app.Compo
+	// Name distinguishes multiple connections in one application, observe
+	// a named Connection with ObserveName. The default Connection has no name.
+	Name string
+	// Tracer, Options and NatsOptions configure the Connection, see Options.
+	Tracer      *Tracer
+	Options     Options
+	NatsOptions []nats.Option
+
+	// Path is the path prefix the Proxy is mounted at, the default is DefaultPath.
+	Path string
+	// URL is the websocket url of the Proxy including the path prefix, for example
+	// wss://realtime.example.com/natsws/. The default uses the browser window location and Path.
+	URL string
+	// ClientName sets the client name instead of choosing one with ClientNameMode.
+	ClientName string
+	// ClientNameMode selects how the client name is chosen, the default is BrowserClientName.
+	ClientNameMode ClientNameMode
+
 	connection *Connection
 }
 
 func (n *Component) Render() app.UI {
 	div := app.Div().Style("display", "none").DataSet("name", "natsws-component")
+	if n.Name != "" {
+		div.DataSet("connection-name", n.Name)
+	}
 	if n.connection != nil {
 		div.DataSet("client-name", n.connection.ClientName())
 	}
@@ -20,7 +43,30 @@ func (n *Component) Render() app.UI {
 }
 
 func (n *Component) OnMount(ctx app.Context) {
-	n.connection = &Connection{appContext: ctx}
+	n.connection = newConnection(appBinding{ctx: ctx}, n.Name)
+	n.connection.path = n.Path
+	n.connection.url = n.URL
+	n.connection.clientName = n.ClientName
+	n.connection.clientNameMode = n.ClientNameMode
+	n.connection.natsOptions = n.NatsOptions
+	n.connection.tracer = n.Tracer
+	n.connection.options = n.Options
 	ctx.Async(n.connection.run)
-	Observe(ctx, n.connection).OnChange(n.Update)
+	ObserveName(ctx, n.Name, n.connection).OnChange(n.Update)
+}
+
+// Close closes the Connection of the Component, see Connection.Close.
+func (n *Component) Close() error {
+	if n.connection == nil {
+		return nats.ErrConnectionClosed
+	}
+	return n.connection.Close()
+}
+
+// Drain drains and closes the Connection of the Component, see Connection.Drain.
+func (n *Component) Drain(timeout time.Duration) error {
+	if n.connection == nil {
+		return nats.ErrConnectionClosed
+	}
+	return n.connection.Drain(timeout)
 }
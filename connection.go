@@ -1,8 +1,8 @@
 package natsws
 
 import (
+	"context"
 	"fmt"
-	"github.com/google/uuid"
 	"github.com/maxence-charriere/go-app/v9/pkg/app"
 	"github.com/maxence-charriere/go-app/v9/pkg/errors"
 	"github.com/nats-io/nats.go"
@@ -11,11 +11,15 @@ import (
 	"nhooyr.io/websocket"
 	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const State = "natsws.Connection"
 const StateClientName = State + ".clientName"
+
+// Ping is the heartbeat subject of earlier releases, set Options.HeartbeatSubject to keep publishing to it.
 const Ping = State + ".ping"
 
 type ChangeReason string
@@ -23,16 +27,62 @@ type ChangeReason string
 const Connect ChangeReason = "connect"
 const Reconnect ChangeReason = "reconnect"
 const Disconnect ChangeReason = "disconnect"
+const Error ChangeReason = "error"
+const LameDuck ChangeReason = "lameDuck"
+const Closed ChangeReason = "closed"
 
 const UseDialer = "GOAPP_NATSWS_DIALER"
 
 type Connection struct {
-	appContext    app.Context
-	clientName    string
-	wsConn        *websocket.Conn
-	natsConn      *nats.Conn
-	subscriptions []*nats.Subscription
-	changeReason  ChangeReason
+	binding    binding
+	name       string
+	path       string
+	url        string
+	clientName string
+	tabId      string
+
+	clientNameMode ClientNameMode
+	natsOptions    []nats.Option
+
+	natsConn     *nats.Conn
+	subs         *subscriptions
+	changeReason ChangeReason
+	lastError    error
+	tracer       *Tracer
+	options      Options
+	latency      *latency
+	outbox       *outbox
+	relay        *relay
+	traffic      *traffic
+	stats        *connectionStats
+
+	// self is the Connection of the run goroutine, shared by the copies observers receive
+	self *Connection
+	// mu guards the fields the run goroutine and nats.go callbacks change while other
+	// goroutines read them, observers receive copies made under mu
+	mu *sync.Mutex
+
+	// control runs functions on the run goroutine that owns the nats connection
+	control       chan func()
+	generation    uint64
+	connectedOnce bool
+	// done is closed when the run goroutine returns
+	done   chan struct{}
+	closed bool
+
+	online       bool
+	hidden       bool
+	suspended    bool
+	suspendTimer *time.Timer
+}
+
+// StateKey returns the go-app state key of the Connection with name,
+// the default Connection has an empty name and uses State.
+func StateKey(name string) string {
+	if name == "" {
+		return State
+	}
+	return State + "/" + name
 }
 
 // Observe simplifies observing the State of the Connection.
@@ -40,8 +90,17 @@ type Connection struct {
 //		The pointer to the Connection must not be nil.
 //	 See example for ways to initialize the Connection pointer.
 func Observe(ctx app.Context, value *Connection) app.Observer {
+	return observe(ctx, "", value)
+}
+
+// ObserveName observes the Connection of the Component with the same Name.
+func ObserveName(ctx app.Context, name string, value *Connection) app.Observer {
+	return observe(ctx, name, value)
+}
+
+func observe(ctx app.Context, name string, value *Connection) app.Observer {
 	logNilConnection(value)
-	observer := ctx.ObserveState(State)
+	observer := ctx.ObserveState(StateKey(name))
 	observer.Value(value)
 	return observer
 }
@@ -49,7 +108,7 @@ func Observe(ctx app.Context, value *Connection) app.Observer {
 func logNilConnection(value *Connection) {
 	if value == nil {
 		// print a warning and show where the call came from
-		_, file, line, ok := runtime.Caller(2)
+		_, file, line, ok := runtime.Caller(3)
 		if ok {
 			fileLine := fmt.Sprintf("%s:%d", file, line)
 			e := errors.Newf("natsws.Observe got nil value from file:line %x", fileLine)
@@ -58,21 +117,77 @@ func logNilConnection(value *Connection) {
 	}
 }
 
+// lock locks mu, it does nothing for the zero Connection an observer starts with.
+func (c *Connection) lock() (unlock func()) {
+	if c.mu == nil {
+		return func() {}
+	}
+	c.mu.Lock()
+	return c.mu.Unlock
+}
+
+// update changes the state with fn while holding mu and notifies observers with a copy
+// of the result, nothing is notified when fn returns false.
+func (c *Connection) update(fn func() bool) {
+	unlock := c.lock()
+	if !fn() {
+		unlock()
+		return
+	}
+	snapshot := *c
+	unlock()
+	c.binding.notify(&snapshot)
+	c.relay.broadcastState(&snapshot)
+}
+
 func (c *Connection) setState() {
-	c.appContext.SetState(State, c)
+	c.update(func() bool { return true })
+}
+
+// setReason records reason and notifies observers.
+func (c *Connection) setReason(reason ChangeReason) {
+	c.update(func() bool {
+		c.changeReason = reason
+		return true
+	})
+}
+
+// setError records err as the LastError and notifies observers with ChangeReason Error.
+func (c *Connection) setError(err error) {
+	c.update(func() bool {
+		c.lastError = err
+		c.changeReason = Error
+		return true
+	})
 }
 
 func (c *Connection) run() {
-	c.ctx().GetState(StateClientName, &c.clientName)
-	if c.clientName == "" {
-		c.clientName = uuid.NewString()
-		c.ctx().SetState(StateClientName, c.clientName, app.Persist)
+	defer close(c.done)
+
+	for attempts := 1; ; attempts++ {
+		err := c.resolveClientName()
+		if err == nil {
+			break
+		}
+		c.setError(err)
+		select {
+		case <-c.ctx().Done():
+			return
+		case <-time.After(c.options.backoff(attempts)):
+		}
 	}
 
-	defer c.unsubscribe()
+	defer c.closeNats(false)
+	defer c.subs.unsubscribe()
+	defer func() { c.relay.stop() }()
+
+	defer c.watchBrowser()()
 
-	keepAlive := time.NewTicker(time.Second * 5)
-	defer keepAlive.Stop()
+	c.outbox.load(c)
+	heartbeat, stopHeartbeat := c.heartbeatTicker()
+	defer stopHeartbeat()
+	statsTick, stopStats := c.statsTicker()
+	defer stopStats()
 
 	initialConnect := make(chan bool, 1)
 	initialConnect <- true
@@ -83,84 +198,327 @@ func (c *Connection) run() {
 		case <-c.ctx().Done():
 			return
 		case <-initialConnect:
-			c.connect()
-		case <-keepAlive.C:
-			if c.natsConn.IsReconnecting() {
+			if !c.startSharing() {
+				c.connect()
+			}
+		case fn := <-c.control:
+			fn()
+			if c.closed {
+				return
+			}
+		case <-heartbeat:
+			conn := c.natsConnection()
+			if conn == nil || !conn.IsConnected() || !c.IsOnline() || c.IsHidden() {
 				break
 			}
-			_ = c.natsConn.Publish(Ping, []byte("ping from "+c.clientName))
+			// RTT blocks until the server responds, keep the run goroutine responsive
+			go c.heartbeat(conn)
+		case <-statsTick:
+			c.binding.setState(StatsKey(c.name), c.Stats(), false)
 		}
 	}
 
 }
 
-func (c *Connection) unsubscribe() {
-	for _, sub := range c.subscriptions {
-		_ = sub.Unsubscribe()
+// do runs fn on the run goroutine without blocking the caller.
+func (c *Connection) do(fn func()) {
+	if c.control == nil {
+		return
 	}
+	go func() {
+		select {
+		case c.control <- fn:
+		case <-c.ctx().Done():
+		case <-c.done:
+		}
+	}()
 }
 
-func (c *Connection) Subscribe(subject string, cb nats.MsgHandler) (err error) {
+// reconnect replaces the nats connection, subscriptions are recreated on the new connection.
+func (c *Connection) reconnect() {
+	if c.closed {
+		return
+	}
+	if c.relay.isFollower() {
+		// the leader tab owns the nats connection
+		c.relay.post(relayMessage{Op: relayReconnect})
+		return
+	}
+	c.closeNats(true)
+	unlock := c.lock()
+	c.suspended = false
+	unlock()
+	c.connect()
+}
+
+// ReconnectNow replaces the nats connection without waiting for the reconnect delay.
+// It can be called on the copy of the Connection an observer receives.
+func (c *Connection) ReconnectNow() {
+	c.do(func() { c.self.reconnect() })
+}
+
+// closeNats closes the nats connection, callbacks from the closed connection are ignored.
+// reconnect is set when the connection is replaced later, it counts as a reconnect in Stats.
+func (c *Connection) closeNats(reconnect bool) {
+	unlock := c.lock()
+	atomic.AddUint64(&c.generation, 1)
+	conn := c.natsConn
+	c.natsConn = nil
+	unlock()
+	if conn != nil {
+		conn.Close()
+		c.stats.replace(conn, reconnect)
+	}
+}
+
+// Subscribe registers cb for messages on subject, unsubscribe removes the subscription.
+//
+// Subscriptions are removed when the Connection stops and are recreated when
+// the Connection replaces its nats connection.
+func (c *Connection) Subscribe(subject string, cb nats.MsgHandler) (unsubscribe func(), err error) {
+	return c.QueueSubscribe(subject, "", cb)
+}
+
+// QueueSubscribe registers cb for messages on subject as a member of queue,
+// each message is delivered to only one member of the queue group.
+func (c *Connection) QueueSubscribe(subject, queue string, cb nats.MsgHandler) (unsubscribe func(), err error) {
+	var sub *subscription
+	if sub, err = c.subscribe(subject, queue, cb); err != nil {
+		return
+	}
+	return func() { _ = c.removeSubscription(sub) }, nil
+}
+
+// ChanSubscribe delivers messages on subject to ch, an empty queue subscribes outside a queue group.
+//
+// Messages are dropped as a slow consumer when ch is full. ch is not closed by unsubscribe.
+func (c *Connection) ChanSubscribe(subject, queue string, ch chan *nats.Msg) (unsubscribe func(), err error) {
+	sub := &subscription{subject: subject, queue: queue, ch: ch}
+	if err = c.addSubscription(sub); err != nil {
+		return
+	}
+	return func() { _ = c.removeSubscription(sub) }, nil
+}
+
+// subscribe registers cb for messages on subject and returns the subscription for removeSubscription.
+func (c *Connection) subscribe(subject, queue string, cb nats.MsgHandler) (sub *subscription, err error) {
+	sub = &subscription{subject: subject, queue: queue, handler: c.tracer.tracedHandler(cb)}
+	if err = c.addSubscription(sub); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// removeSubscription unsubscribes sub, a relaying tab asks the leader tab to unsubscribe.
+func (c *Connection) removeSubscription(sub *subscription) (err error) {
+	if c.subs == nil {
+		return
+	}
+	err = c.subs.remove(sub)
+	if c.relay.isFollower() {
+		c.relay.unsubscribe(sub)
+	}
+	return
+}
+
+func (c *Connection) addSubscription(sub *subscription) (err error) {
+	if c.relay.isFollower() {
+		if sub.custom != nil {
+			return ErrRelayed
+		}
+		if !c.relay.isConnected() {
+			return fmt.Errorf("not connected")
+		}
+		c.subs.addRelayed(c.relay, sub)
+		return
+	}
+
 	var conn *nats.Conn
 	if conn, err = c.Nats(); err != nil {
 		return
 	}
+	return c.subs.add(conn, sub)
+}
 
-	var sub *nats.Subscription
-	if sub, err = conn.Subscribe(subject, cb); err != nil {
+func (c *Connection) Publish(subject string, message []byte) (err error) {
+	msg := nats.NewMsg(subject)
+	msg.Data = message
+	return c.PublishMsg(msg)
+}
+
+// PublishMsg publishes msg including its headers.
+//
+// When Options.OutboxSize is set, messages published while not connected, or
+// while older messages are still queued, go to the outbox instead of failing,
+// see PublishOutbox.
+func (c *Connection) PublishMsg(msg *nats.Msg) (err error) {
+	defer func() { c.traffic.emit(TrafficPublish, msg, err) }()
+
+	if c.outbox != nil && c.options.OutboxSize > 0 && (!c.IsConnected() || c.outbox.queued()) {
+		_, err = c.PublishOutbox(msg, 0, nil)
 		return
 	}
 
-	c.subscriptions = append(c.subscriptions, sub)
+	if c.relay.isFollower() {
+		return c.relay.publish(msg)
+	}
+
+	var conn *nats.Conn
+	if conn, err = c.Nats(); err != nil {
+		return
+	}
+
+	span := c.tracer.StartSend("publish "+msg.Subject, msg)
+	err = conn.PublishMsg(msg)
+	span.Finish(err)
 	return
 }
 
-func (c *Connection) Publish(subject string, message []byte) (err error) {
+// Request sends data to subject and waits up to timeout for the response.
+func (c *Connection) Request(subject string, data []byte, timeout time.Duration) (response *nats.Msg, err error) {
+	msg := nats.NewMsg(subject)
+	msg.Data = data
+	return c.RequestMsg(msg, timeout)
+}
+
+// RequestMsg sends msg including its headers and waits up to timeout for the response.
+func (c *Connection) RequestMsg(msg *nats.Msg, timeout time.Duration) (response *nats.Msg, err error) {
+	defer func() {
+		c.traffic.emit(TrafficRequest, msg, err)
+		c.traffic.emit(TrafficResponse, response, nil)
+	}()
+
+	if c.relay.isFollower() {
+		return c.relay.requestMsg(msg, timeout)
+	}
+
 	var conn *nats.Conn
 	if conn, err = c.Nats(); err != nil {
 		return
 	}
 
-	err = conn.Publish(subject, message)
+	span := c.tracer.StartSend("request "+msg.Subject, msg)
+	response, err = conn.RequestMsg(msg, timeout)
+	span.Finish(err)
 	return
 }
 
 func (c *Connection) connect() {
+	if c.closed {
+		return
+	}
 
 	var opts []nats.Option
 
-	if app.Getenv(UseDialer) != "" {
+	// current is false in callbacks of a nats connection replaced by reconnect
+	generation := atomic.LoadUint64(&c.generation)
+	current := func() bool { return atomic.LoadUint64(&c.generation) == generation }
+	// report applies a change of the current nats connection and notifies observers
+	report := func(fn func()) {
+		c.update(func() bool {
+			if !current() {
+				return false
+			}
+			fn()
+			return true
+		})
+	}
+
+	if c.binding.getenv(UseDialer) != "" {
 		opts = append(opts, nats.SetCustomDialer(&customDialer{connection: c}))
 	} else {
 		opts = append(opts, nats.InProcessServer(c))
 	}
 
 	opts = append(opts, nats.RetryOnFailedConnect(true))
-	opts = append(opts, nats.Name(c.ClientName()))
+	// the tab id lets the proxy and server monitoring tell tabs of the same client apart
+	opts = append(opts, nats.Name(c.ClientName()+" tab "+c.TabID()))
+	opts = append(opts, c.options.natsOptions(c, current)...)
 
 	opts = append(opts, nats.ConnectHandler(func(conn *nats.Conn) {
-		c.changeReason = Connect
-		c.setState()
+		c.stats.connect()
+		report(func() {
+			// the handler can run before nats.Connect returns, observers subscribe on Connect
+			c.natsConn = conn
+			// subscriptions are recreated on a replaced connection, so report Reconnect
+			// to prevent observers from subscribing again
+			c.changeReason = Connect
+			if c.connectedOnce {
+				c.changeReason = Reconnect
+			}
+			c.connectedOnce = true
+		})
+		c.subscribeCustom(conn, current)
+		c.do(c.flushOutbox)
 	}))
 	opts = append(opts, nats.ReconnectHandler(func(conn *nats.Conn) {
-		c.changeReason = Reconnect
-		c.setState()
+		c.stats.connect()
+		report(func() { c.changeReason = Reconnect })
+		c.subscribeCustom(conn, current)
+		c.do(c.flushOutbox)
 	}))
 	opts = append(opts, nats.DisconnectErrHandler(func(conn *nats.Conn, err error) {
-		c.changeReason = Disconnect
-		c.setState()
+		report(func() {
+			if err != nil {
+				c.lastError = err
+			}
+			c.changeReason = Disconnect
+		})
+	}))
+	opts = append(opts, nats.ErrorHandler(func(conn *nats.Conn, sub *nats.Subscription, err error) {
+		// permission violations, slow consumers and other asynchronous errors
+		if sub != nil {
+			err = fmt.Errorf("subscription %q: %w", sub.Subject, err)
+		}
+		report(func() {
+			c.lastError = err
+			c.changeReason = Error
+		})
+	}))
+	opts = append(opts, nats.ClosedHandler(func(conn *nats.Conn) {
+		err := conn.LastError()
+		if err == nil {
+			err = nats.ErrConnectionClosed
+		}
+		report(func() {
+			c.lastError = err
+			c.changeReason = Error
+		})
+	}))
+	opts = append(opts, nats.LameDuckModeHandler(func(conn *nats.Conn) {
+		report(func() { c.changeReason = LameDuck })
 	}))
 
+	opts = append(opts, c.natsOptions...)
+
 	// remove the wss or ws scheme from the connection url to prevent
 	// websocket upgrade negotiation in the client
 	natsUrl := c.wsUrl()
 	natsUrl = strings.TrimPrefix(natsUrl, "ws://")
 	natsUrl = strings.TrimPrefix(natsUrl, "wss://")
-	c.natsConn, _ = nats.Connect(natsUrl, opts...)
+	conn, err := nats.Connect(natsUrl, opts...)
+	if err != nil {
+		c.setError(err)
+	}
+	if conn != nil {
+		unlock := c.lock()
+		c.natsConn = conn
+		unlock()
+		_ = c.subs.resubscribe(conn)
+		c.relay.leaderConnected(conn)
+	}
 
 	return
 }
 
+// subscribeCustom recreates JetStream consumers and other custom subscriptions once
+// conn is connected, failures are reported and retried on the next connect.
+func (c *Connection) subscribeCustom(conn *nats.Conn, current func() bool) {
+	if err := c.subs.subscribeCustom(conn); err != nil && current() {
+		c.setError(err)
+	}
+}
+
 type customDialer struct {
 	connection *Connection
 }
@@ -171,65 +529,142 @@ func (d *customDialer) SkipTLSHandshake() bool {
 
 func (d *customDialer) Dial(_, _ string) (net.Conn, error) {
 	c := d.connection
-	u := fmt.Sprintf("%s/natsws/%s", c.wsUrl(), c.clientName)
-	conn, _, err := websocket.Dial(c.ctx(), u, nil)
+	u := c.wsUrl()
+	dialCtx, cancel := c.dialContext()
+	defer cancel()
+	conn, _, err := websocket.Dial(dialCtx, u, nil)
 	if err != nil {
-		fmt.Println("customDialer websocket.Dial error", err)
+		c.setError(err)
 		return nil, err
 	}
-	netConn := websocket.NetConn(c.ctx(), conn, websocket.MessageBinary)
-	return netConn, nil
+	return c.netConn(conn), nil
 }
 
 func (c *Connection) InProcessConn() (netConn net.Conn, err error) {
 	opts := &websocket.DialOptions{}
 
-	if c.wsConn, _, err = websocket.Dial(c.ctx(), c.wsUrl(), opts); err != nil {
+	dialCtx, cancel := c.dialContext()
+	defer cancel()
+	var wsConn *websocket.Conn
+	if wsConn, _, err = websocket.Dial(dialCtx, c.wsUrl(), opts); err != nil {
+		c.setError(err)
 		return
 	}
 
-	netConn = websocket.NetConn(c.ctx(), c.wsConn, websocket.MessageBinary)
+	netConn = c.netConn(wsConn)
 	return
 }
 
-func (c *Connection) wsUrl() string {
-	natsWsConn := app.Getenv(UseDialer)
-	if natsWsConn != "" {
-		return natsWsConn
+// netConn returns the stream of nats protocol operations over wsConn. Messages from the
+// Proxy are read up to ObjectReadLimit, they come from the nats server.
+func (c *Connection) netConn(wsConn *websocket.Conn) net.Conn {
+	wsConn.SetReadLimit(ObjectReadLimit)
+	return frameConn{websocket.NetConn(c.ctx(), wsConn, websocket.MessageBinary)}
+}
+
+// frameConn splits writes into websocket messages of at most DefaultReadLimit bytes. nats.go
+// writes its buffered operations at once, which with large payloads such as Object Store
+// chunks exceeds the read limit of a Proxy. The nats server reads the messages as a stream.
+type frameConn struct {
+	net.Conn
+}
+
+func (f frameConn) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		frame := p
+		if len(frame) > DefaultReadLimit {
+			frame = frame[:DefaultReadLimit]
+		}
+		var written int
+		written, err = f.Conn.Write(frame)
+		n += written
+		if err != nil {
+			return
+		}
+		p = p[len(frame):]
 	}
-	scheme := "ws" + strings.TrimPrefix(c.windowUrl().Scheme, "http")
+	return
+}
 
-	u := fmt.Sprintf("%s://%s/natsws/%s", scheme, c.windowUrl().Host, c.ClientName())
+// DefaultPath is the default path prefix of the Proxy.
+const DefaultPath = "/natsws/"
 
-	return u
+// proxyUrl returns the websocket url of the Proxy path prefix ending in a slash, the
+// UseDialer environment replaces the scheme and host of the browser window location.
+func (c *Connection) proxyUrl() string {
+	if c.url != "" {
+		return strings.TrimSuffix(c.url, "/") + "/"
+	}
+
+	base := c.binding.getenv(UseDialer)
+	if base == "" {
+		scheme := "ws" + strings.TrimPrefix(c.windowUrl().Scheme, "http")
+		base = fmt.Sprintf("%s://%s", scheme, c.windowUrl().Host)
+	}
+
+	prefix := c.path
+	if prefix == "" {
+		prefix = DefaultPath
+	}
+	prefix = "/" + strings.Trim(prefix, "/") + "/"
+
+	return strings.TrimSuffix(base, "/") + prefix
+}
+
+// wsUrl returns the websocket url of the Proxy for this client and tab.
+func (c *Connection) wsUrl() string {
+	return c.proxyUrl() + c.ClientName() + "?tab=" + url.QueryEscape(c.TabID())
 }
 
+// Nats returns the nats connection when connected. Tabs relaying through
+// another tab with Options.ShareAcrossTabs have no nats connection.
 func (c *Connection) Nats() (conn *nats.Conn, err error) {
-	if c.natsConn == nil || !c.natsConn.IsConnected() {
+	if conn = c.natsConnection(); conn == nil || !conn.IsConnected() {
 		return nil, fmt.Errorf("not connected")
 	}
-	return c.natsConn, nil
+	return
+}
+
+// natsConnection returns the nats connection, connected or not.
+func (c *Connection) natsConnection() *nats.Conn {
+	defer c.lock()()
+	return c.natsConn
 }
 
 func (c *Connection) windowUrl() *url.URL {
-	return app.Window().URL()
+	return c.binding.windowUrl()
 }
 
-func (c *Connection) ctx() app.Context {
-	return c.appContext
+func (c *Connection) ctx() context.Context {
+	return c.binding.context()
 }
 
 func (c *Connection) ClientName() string {
+	defer c.lock()()
 	return c.clientName
 }
 
 func (c *Connection) IsConnected() bool {
-	if c.natsConn == nil {
-		return false
+	if c.relay.isFollower() {
+		return c.relay.isConnected()
 	}
-	return c.natsConn.IsConnected()
+	conn := c.natsConnection()
+	return conn != nil && conn.IsConnected()
 }
 
 func (c *Connection) ChangeReason() ChangeReason {
+	defer c.lock()()
 	return c.changeReason
 }
+
+// LastError returns the most recent error of the Connection, it is not
+// cleared on reconnect and explains why the Connection is offline.
+func (c *Connection) LastError() error {
+	defer c.lock()()
+	return c.lastError
+}
+
+// Name returns the name of the Connection, the default Connection has an empty name.
+func (c *Connection) Name() string {
+	return c.name
+}
@@ -0,0 +1,79 @@
+package natsws
+
+import (
+	"context"
+	"github.com/maxence-charriere/go-app/v9/pkg/app"
+	"github.com/nats-io/nats.go"
+)
+
+// Dispatch returns a nats.MsgHandler that calls cb on the go-app UI goroutine
+// through ctx.Dispatch, the component of ctx is updated after cb returns.
+//
+// nats.go calls handlers on its own goroutine, use Dispatch for any handler
+// that modifies component fields.
+func Dispatch(ctx app.Context, cb func(ctx app.Context, msg *nats.Msg)) nats.MsgHandler {
+	return func(msg *nats.Msg) {
+		ctx.Dispatch(func(ctx app.Context) {
+			cb(ctx, msg)
+		})
+	}
+}
+
+// SubscribeDispatch subscribes to subject and calls cb on the UI goroutine of the component of ctx.
+//
+// The subscription lasts as long as the Connection, call unsubscribe in OnDismount of the component.
+func (c *Connection) SubscribeDispatch(ctx app.Context, subject string,
+	cb func(ctx app.Context, msg *nats.Msg)) (unsubscribe func(), err error) {
+
+	var sub *subscription
+	if sub, err = c.subscribe(subject, "", Dispatch(ctx, cb)); err != nil {
+		return
+	}
+	return func() { _ = c.removeSubscription(sub) }, nil
+}
+
+const subscriptionBuffer = 64
+
+// Subscription is a pull style subscription created by Connection.SubscribeSync.
+type Subscription struct {
+	ch   chan *nats.Msg
+	conn *Connection
+	sub  *subscription
+}
+
+// NextMsg waits for the next message or until ctx is done. It returns
+// nats.ErrBadSubscription once the subscription was removed.
+func (s *Subscription) NextMsg(ctx context.Context) (*nats.Msg, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case msg, ok := <-s.ch:
+		if !ok {
+			return nil, nats.ErrBadSubscription
+		}
+		return msg, nil
+	}
+}
+
+// Messages returns the channel messages are delivered on, for use with range.
+// The channel is closed by Unsubscribe or when the Connection stops.
+func (s *Subscription) Messages() <-chan *nats.Msg {
+	return s.ch
+}
+
+// Unsubscribe removes the subscription and closes the channel of Messages.
+func (s *Subscription) Unsubscribe() error {
+	return s.conn.removeSubscription(s.sub)
+}
+
+// SubscribeSync subscribes to subject for consumption with NextMsg or Messages.
+//
+// Up to 64 messages are buffered, further messages are dropped as a slow consumer.
+func (c *Connection) SubscribeSync(subject string) (sub *Subscription, err error) {
+	ch := make(chan *nats.Msg, subscriptionBuffer)
+	sub = &Subscription{ch: ch, conn: c, sub: &subscription{subject: subject, ch: ch, closeCh: true}}
+	if err = c.addSubscription(sub.sub); err != nil {
+		return nil, err
+	}
+	return
+}
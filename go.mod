@@ -5,6 +5,7 @@ go 1.19
 require (
 	github.com/google/uuid v1.3.1
 	github.com/maxence-charriere/go-app/v9 v9.8.0
+	github.com/nats-io/nats-server/v2 v2.9.21
 	github.com/nats-io/nats.go v1.28.0
 	nhooyr.io/websocket v1.8.7
 )
@@ -12,10 +13,13 @@ require (
 require (
 	github.com/golang/protobuf v1.5.0 // indirect
 	github.com/klauspost/compress v1.16.7 // indirect
-	github.com/nats-io/nats-server/v2 v2.9.21 // indirect
+	github.com/minio/highwayhash v1.0.2 // indirect
+	github.com/nats-io/jwt/v2 v2.4.1 // indirect
 	github.com/nats-io/nkeys v0.4.4 // indirect
 	github.com/nats-io/nuid v1.0.1 // indirect
+	go.uber.org/automaxprocs v1.5.3 // indirect
 	golang.org/x/crypto v0.11.0 // indirect
 	golang.org/x/sys v0.10.0 // indirect
+	golang.org/x/time v0.3.0 // indirect
 	google.golang.org/protobuf v1.31.0 // indirect
 )
@@ -0,0 +1,50 @@
+package natsws
+
+import (
+	"github.com/nats-io/nats.go"
+)
+
+const ContentTypeHeader = "Content-Type"
+
+// NewMsg builds a message for Connection.PublishMsg or Connection.RequestMsg
+// with headers from alternating key value pairs. A trailing key without
+// a value is added with an empty value rather than dropped.
+//
+//	msg := natsws.NewMsg("orders.create", body, natsws.ContentTypeHeader, "application/json", nats.MsgIdHdr, id)
+func NewMsg(subject string, data []byte, keyValues ...string) *nats.Msg {
+	msg := nats.NewMsg(subject)
+	msg.Data = data
+	for i := 0; i < len(keyValues); i += 2 {
+		value := ""
+		if i+1 < len(keyValues) {
+			value = keyValues[i+1]
+		}
+		msg.Header.Add(keyValues[i], value)
+	}
+	return msg
+}
+
+// HeaderValue returns the first value of key from the headers of msg or "" when not present.
+func HeaderValue(msg *nats.Msg, key string) string {
+	if msg == nil {
+		return ""
+	}
+	return msg.Header.Get(key)
+}
+
+// HeaderValues returns all values of key from the headers of msg.
+func HeaderValues(msg *nats.Msg, key string) []string {
+	if msg == nil {
+		return nil
+	}
+	return msg.Header.Values(key)
+}
+
+// WithHeader wraps cb so that it is only called for messages where the header key has value.
+func WithHeader(key, value string, cb nats.MsgHandler) nats.MsgHandler {
+	return func(msg *nats.Msg) {
+		if HeaderValue(msg, key) == value {
+			cb(msg)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package natsws
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewMsgHeaders(t *testing.T) {
+	msg := NewMsg("subject", []byte("data"), "A", "1", "A", "2", "B", "3")
+	if msg.Subject != "subject" || string(msg.Data) != "data" {
+		t.Fatalf("unexpected message %+v", msg)
+	}
+	if values := HeaderValues(msg, "A"); !reflect.DeepEqual(values, []string{"1", "2"}) || HeaderValue(msg, "B") != "3" {
+		t.Fatalf("unexpected headers %v", msg.Header)
+	}
+
+	// a trailing key without a value is kept with an empty value
+	msg = NewMsg("subject", nil, "A", "1", "Trailing")
+	if values, ok := msg.Header["Trailing"]; !ok || !reflect.DeepEqual(values, []string{""}) || HeaderValue(msg, "A") != "1" {
+		t.Fatalf("unexpected headers %v", msg.Header)
+	}
+
+	if msg = NewMsg("subject", nil); len(msg.Header) != 0 {
+		t.Fatalf("expected no headers, got %v", msg.Header)
+	}
+}
@@ -0,0 +1,98 @@
+package natsws
+
+import (
+	"github.com/nats-io/nats.go"
+	"sync"
+	"time"
+)
+
+const defaultHeartbeatInterval = 5 * time.Second
+
+// latencyWeight is the weight of a new sample in the average latency.
+const latencyWeight = 0.2
+
+// latencyNotifyInterval limits how often a new latency sample notifies observers.
+const latencyNotifyInterval = time.Second
+
+// latency holds round trip measurements shared by all copies of a Connection.
+type latency struct {
+	mu       sync.Mutex
+	current  time.Duration
+	average  time.Duration
+	notified time.Time
+}
+
+// add records rtt and reports if observers should be notified of the new sample.
+func (l *latency) add(rtt time.Duration) (notify bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.average == 0 {
+		l.average = rtt
+	} else {
+		l.average = time.Duration(latencyWeight*float64(rtt) + (1-latencyWeight)*float64(l.average))
+	}
+	l.current = rtt
+	if time.Since(l.notified) < latencyNotifyInterval {
+		return false
+	}
+	l.notified = time.Now()
+	return true
+}
+
+func (l *latency) get() (current, average time.Duration) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.current, l.average
+}
+
+// heartbeatTicker returns the heartbeat channel, or nil when heartbeats are disabled.
+func (c *Connection) heartbeatTicker() (tick <-chan time.Time, stop func()) {
+	if c.options.DisableHeartbeat {
+		return nil, func() {}
+	}
+	interval := c.options.HeartbeatInterval
+	if interval <= 0 {
+		interval = defaultHeartbeatInterval
+	}
+	ticker := time.NewTicker(interval)
+	return ticker.C, ticker.Stop
+}
+
+// heartbeat measures the round trip time to the server and publishes
+// to Options.HeartbeatSubject when one is configured.
+func (c *Connection) heartbeat(conn *nats.Conn) {
+	rtt, err := conn.RTT()
+	if err != nil {
+		return
+	}
+	if c.latency.add(rtt) {
+		c.notifyLatency()
+		c.relay.broadcastLatency(rtt)
+	}
+	if c.options.HeartbeatSubject != "" {
+		_ = conn.Publish(c.options.HeartbeatSubject, []byte("ping from "+c.ClientName()))
+	}
+}
+
+// notifyLatency notifies observers of a new latency sample without changing the ChangeReason.
+func (c *Connection) notifyLatency() {
+	unlock := c.lock()
+	snapshot := *c
+	unlock()
+	c.binding.notify(&snapshot)
+}
+
+// Latency returns the round trip time measured by the most recent heartbeat.
+func (c *Connection) Latency() time.Duration {
+	current, _ := c.latency.get()
+	return current
+}
+
+// AverageLatency returns the exponentially weighted average of the heartbeat round trip times.
+func (c *Connection) AverageLatency() time.Duration {
+	_, average := c.latency.get()
+	return average
+}
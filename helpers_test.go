@@ -0,0 +1,181 @@
+//go:build !wasm
+
+package natsws
+
+import (
+	"context"
+	"fmt"
+	"github.com/maxence-charriere/go-app/v9/pkg/app"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"nhooyr.io/websocket"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testTimeout bounds the tests that run a nats server.
+const testTimeout = 10 * time.Second
+
+// browserDialer dials the Proxy like the browser does, one nats client write per websocket message.
+type browserDialer struct {
+	url string
+}
+
+func (d *browserDialer) Dial(_, _ string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	options := &websocket.DialOptions{HTTPHeader: http.Header{"User-Agent": {"Mozilla/5.0 natsws test"}}}
+	conn, _, err := websocket.Dial(ctx, d.url, options)
+	if err != nil {
+		return nil, err
+	}
+	return websocket.NetConn(context.Background(), conn, websocket.MessageBinary), nil
+}
+
+func (d *browserDialer) SkipTLSHandshake() bool {
+	return true
+}
+
+func freePort(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = listener.Close() }()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// runNatsServer starts a nats server with JetStream and a websocket listener for the test.
+func runNatsServer(t *testing.T) (natsServer *server.Server, websocketUrl string) {
+	wsPort := freePort(t)
+	natsServer, err := server.NewServer(&server.Options{
+		Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir(), NoSigs: true,
+		Websocket: server.WebsocketOpts{Host: "127.0.0.1", Port: wsPort, NoTLS: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go natsServer.Start()
+	t.Cleanup(natsServer.Shutdown)
+	if !natsServer.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server not ready")
+	}
+	return natsServer, fmt.Sprintf("ws://127.0.0.1:%d", wsPort)
+}
+
+// newTestProxy starts a nats server and a Proxy for it, configure may change the Proxy, whose
+// Manager is a StaticManager of the nats server. It returns the websocket url of the Proxy path prefix.
+func newTestProxy(t *testing.T, configure func(proxy *Proxy)) (natsServer *server.Server, wsUrl string) {
+	natsServer, backend := runNatsServer(t)
+	proxy := &Proxy{Manager: StaticManager(false, backend)}
+	if configure != nil {
+		configure(proxy)
+	}
+	httpServer := httptest.NewServer(proxy)
+	t.Cleanup(httpServer.Close)
+	return natsServer, strings.Replace(httpServer.URL, "http", "ws", 1) + DefaultPath
+}
+
+// testConnection is a connected Connection of a test, dialed through a Proxy of a nats server.
+type testConnection struct {
+	*Connection
+	// ctx is done after testTimeout, it runs the Connections of the test
+	ctx        context.Context
+	natsServer *server.Server
+	dialer     *Dialer
+}
+
+// newTestConnection starts a nats server and a Proxy and dials a Connection with the client name
+// "test", configure may change the Proxy and the Dialer first. Connections are closed when the test ends.
+func newTestConnection(t *testing.T, configure ...func(proxy *Proxy, dialer *Dialer)) *testConnection {
+	t.Helper()
+	dialer := &Dialer{ClientName: "test"}
+	natsServer, wsUrl := newTestProxy(t, func(proxy *Proxy) {
+		for _, fn := range configure {
+			fn(proxy, dialer)
+		}
+	})
+	dialer.URL = wsUrl
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	t.Cleanup(cancel)
+	tc := &testConnection{ctx: ctx, natsServer: natsServer, dialer: dialer}
+	tc.Connection = tc.dial(t)
+	return tc
+}
+
+// dial dials another connected Connection with the Dialer of the test, like another tab of the client.
+func (tc *testConnection) dial(t *testing.T) *Connection {
+	t.Helper()
+	conn, err := tc.dialer.Dial(tc.ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	if err = conn.WaitConnected(tc.ctx); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// backend connects to the nats server directly, for example to set up JetStream assets.
+func (tc *testConnection) backend(t *testing.T) (conn *nats.Conn, js nats.JetStreamContext) {
+	t.Helper()
+	conn, err := nats.Connect(tc.natsServer.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conn.Close)
+	if js, err = conn.JetStream(); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func waitFor(t *testing.T, ctx context.Context, what string, done func() bool) {
+	t.Helper()
+	for !done() {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("%s timed out", what)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// testCompo keeps the app.Context it is mounted with.
+type testCompo struct {
+	app.Compo
+	ctx app.Context
+}
+
+func (c *testCompo) OnMount(ctx app.Context) {
+	c.ctx = ctx
+}
+
+// asyncContext runs Async and Dispatch without the UI goroutine of the client tester,
+// which waits for Async functions before it handles dispatches.
+type asyncContext struct {
+	app.Context
+	ctx context.Context
+}
+
+func (c asyncContext) Async(fn func()) {
+	go fn()
+}
+
+func (c asyncContext) Dispatch(fn func(app.Context)) {
+	fn(c)
+}
+
+func (c asyncContext) Done() <-chan struct{} {
+	return c.ctx.Done()
+}
+
+func (c asyncContext) Err() error {
+	return c.ctx.Err()
+}
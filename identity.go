@@ -0,0 +1,156 @@
+package natsws
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"net/http"
+	"strings"
+)
+
+// ClientNameMode selects how a Connection chooses its client name when
+// Component.ClientName is not set.
+type ClientNameMode string
+
+// BrowserClientName persists a generated UUID in local storage, all tabs of the browser share it.
+const BrowserClientName ClientNameMode = "browser"
+
+// TabClientName uses the tab id, which is kept in session storage and survives reloads of the tab.
+const TabClientName ClientNameMode = "tab"
+
+// ServerClientName requests the client name from the IdentityPath of the Proxy,
+// see ClientNamer for the server side.
+const ServerClientName ClientNameMode = "server"
+
+// IdentityPath is requested below the Proxy path prefix by connections using ServerClientName.
+const IdentityPath = "identity"
+
+// Identity is the response of the Proxy for IdentityPath.
+type Identity struct {
+	ClientName string `json:"clientName"`
+}
+
+// ClientNamer is implemented by a Manager that assigns client names to
+// connections using ServerClientName, for example from an authenticated session.
+//
+// The Proxy also checks the client name of each websocket session against ClientName and
+// rejects sessions with another name, so a client cannot claim the name of another client.
+type ClientNamer interface {
+	// ClientName returns the client name for the request, an error responds with http.StatusUnauthorized.
+	ClientName(request *http.Request) (string, error)
+}
+
+// resolveClientName chooses the client name and tab id before the first connect.
+func (c *Connection) resolveClientName() (err error) {
+	tabId, clientName := c.TabID(), c.ClientName()
+	defer func() {
+		unlock := c.lock()
+		c.tabId, c.clientName = tabId, clientName
+		unlock()
+	}()
+
+	tabIdKey := StateKey(c.name) + ".tabId"
+	if tabId == "" {
+		c.binding.getSession(tabIdKey, &tabId)
+		if tabId == "" {
+			tabId = uuid.NewString()
+			c.binding.setSession(tabIdKey, tabId)
+		}
+	}
+
+	if clientName != "" {
+		// supplied through Component.ClientName or already resolved
+		return
+	}
+
+	switch c.clientNameMode {
+	case TabClientName:
+		clientName = tabId
+	case ServerClientName:
+		clientName, err = c.requestClientName()
+	default:
+		clientNameKey := StateKey(c.name) + ".clientName"
+		c.binding.getState(clientNameKey, &clientName)
+		if clientName == "" {
+			clientName = uuid.NewString()
+			c.binding.setState(clientNameKey, clientName, true)
+		}
+	}
+	return
+}
+
+func (c *Connection) requestClientName() (clientName string, err error) {
+	identityUrl := "http" + strings.TrimPrefix(c.proxyUrl(), "ws") + IdentityPath
+
+	var request *http.Request
+	if request, err = http.NewRequestWithContext(c.ctx(), http.MethodGet, identityUrl, nil); err != nil {
+		return
+	}
+
+	var response *http.Response
+	if response, err = http.DefaultClient.Do(request); err != nil {
+		return
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("natsws identity request failed with status %d", response.StatusCode)
+	}
+
+	var identity Identity
+	if err = json.NewDecoder(response.Body).Decode(&identity); err != nil {
+		return
+	}
+	if identity.ClientName == "" {
+		return "", fmt.Errorf("natsws identity response has no client name")
+	}
+	return identity.ClientName, nil
+}
+
+// serveIdentity responds to IdentityPath requests with the client name assigned by the Manager.
+func (p *Proxy) serveIdentity(writer http.ResponseWriter, request *http.Request) {
+	namer, ok := p.Manager.(ClientNamer)
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	clientName, err := namer.ClientName(request)
+	if err != nil {
+		p.Manager.OnError("Proxy ClientNamer.ClientName", err)
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(writer).Encode(Identity{ClientName: clientName})
+}
+
+// checkClientName verifies that the Manager assigned clientId to the client of a websocket
+// session, it responds and returns false when it did not.
+func (p *Proxy) checkClientName(writer http.ResponseWriter, request *http.Request, clientId string) bool {
+	namer, ok := p.Manager.(ClientNamer)
+	if !ok {
+		return true
+	}
+
+	clientName, err := namer.ClientName(request)
+	if err != nil {
+		p.Manager.OnError("Proxy ClientNamer.ClientName", err)
+		writer.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+	if clientName != clientId {
+		p.Manager.OnError("Proxy ClientNamer.ClientName", fmt.Errorf("client name %q was not assigned to the client", clientId))
+		writer.WriteHeader(http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// TabID returns the id of the browser tab, it is part of the nats connection name.
+func (c *Connection) TabID() string {
+	defer c.lock()()
+	return c.tabId
+}
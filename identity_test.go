@@ -0,0 +1,66 @@
+//go:build !wasm
+
+package natsws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"nhooyr.io/websocket"
+	"strings"
+	"testing"
+	"time"
+)
+
+// userManager assigns the client name from the X-User header of the request.
+type userManager struct {
+	Manager
+}
+
+func (m *userManager) ClientName(request *http.Request) (string, error) {
+	if user := request.Header.Get("X-User"); user != "" {
+		return user, nil
+	}
+	return "", errors.New("no user")
+}
+
+func TestProxyRejectsSpoofedClientName(t *testing.T) {
+	_, wsUrl := newTestProxy(t, func(proxy *Proxy) { proxy.Manager = &userManager{Manager: proxy.Manager} })
+
+	header := http.Header{"X-User": {"alice"}}
+	request, _ := http.NewRequest(http.MethodGet, strings.Replace(wsUrl, "ws", "http", 1)+IdentityPath, nil)
+	request.Header = header
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var identity Identity
+	err = json.NewDecoder(response.Body).Decode(&identity)
+	_ = response.Body.Close()
+	if err != nil || identity.ClientName != "alice" {
+		t.Fatalf("unexpected identity %+v %v", identity, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tests := []struct {
+		clientId string
+		header   http.Header
+		status   int
+	}{
+		{"alice", header, http.StatusSwitchingProtocols},
+		{"bob", header, http.StatusForbidden},
+		{"alice", nil, http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		conn, response, err := websocket.Dial(ctx, wsUrl+test.clientId+"?tab=1", &websocket.DialOptions{HTTPHeader: test.header})
+		if response == nil || response.StatusCode != test.status {
+			t.Fatalf("client %q: expected status %d, got %v %v", test.clientId, test.status, response, err)
+		}
+		if conn != nil {
+			_ = conn.Close(websocket.StatusNormalClosure, "")
+		}
+	}
+}
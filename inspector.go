@@ -0,0 +1,181 @@
+package natsws
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/maxence-charriere/go-app/v9/pkg/app"
+	"github.com/nats-io/nats.go"
+	"strings"
+	"time"
+)
+
+// InspectorEnv enables the Inspector when set in the app.Handler environment.
+const InspectorEnv = "DEV"
+
+const defaultInspectorLimit = 200
+
+const inspectorPreview = 2048
+
+const inspectorTimeout = 5 * time.Second
+
+var _ app.Mounter = (*Inspector)(nil)
+var _ app.Dismounter = (*Inspector)(nil)
+
+// Inspector is a development tool that shows the traffic of the Connection of the Component
+// with the same Name, lists its subscriptions and publishes or requests by hand.
+//
+// It renders nothing unless InspectorEnv is set, the same gate Root.OnAppUpdate of the demo uses.
+type Inspector struct {
+	app.Compo
+	// Name is compared with Component.Name to find the Connection to inspect, an empty Name inspects
+	// the default Connection.
+	Name string
+	// Limit is the number of events kept, 200 when zero.
+	Limit int
+
+	conn     Connection
+	remove   func()
+	events   []TrafficEvent
+	filter   string
+	subject  string
+	payload  string
+	response string
+}
+
+func inspectorEnabled() bool {
+	return app.Getenv(InspectorEnv) != ""
+}
+
+func (i *Inspector) OnMount(ctx app.Context) {
+	if !inspectorEnabled() {
+		return
+	}
+	ObserveName(ctx, i.Name, &i.conn).OnChange(func() {
+		// the first state of the Connection carries the shared traffic taps
+		if i.remove == nil && i.conn.traffic != nil {
+			i.remove = i.conn.Tap(func(event TrafficEvent) {
+				ctx.Dispatch(func(ctx app.Context) { i.add(event) })
+			})
+		}
+		i.Update()
+	})
+}
+
+func (i *Inspector) OnDismount() {
+	if i.remove != nil {
+		i.remove()
+		i.remove = nil
+	}
+}
+
+func (i *Inspector) add(event TrafficEvent) {
+	limit := i.Limit
+	if limit <= 0 {
+		limit = defaultInspectorLimit
+	}
+	i.events = append(i.events, event)
+	if len(i.events) > limit {
+		i.events = i.events[len(i.events)-limit:]
+	}
+}
+
+func (i *Inspector) Render() app.UI {
+	if !inspectorEnabled() {
+		return app.Div().Style("display", "none")
+	}
+
+	var rows []app.UI
+	for n := len(i.events) - 1; n >= 0; n-- {
+		event := i.events[n]
+		if i.filter != "" && !strings.Contains(event.Subject, i.filter) {
+			continue
+		}
+		rows = append(rows, i.renderEvent(event))
+	}
+
+	var subs []app.UI
+	for _, info := range i.conn.Subscriptions() {
+		subs = append(subs, app.Tr().Body(
+			app.Td().Text(info.Subject),
+			app.Td().Text(info.Queue),
+			app.Td().Text(info.Received),
+			app.Td().Text(info.Dropped),
+		))
+	}
+
+	return app.Div().Class("natsws-inspector").Body(
+		app.Div().Class("natsws-inspector-form").Body(
+			app.Input().Placeholder("subject").Value(i.subject).OnChange(i.ValueTo(&i.subject)),
+			app.Textarea().Placeholder("payload").Text(i.payload).OnChange(i.ValueTo(&i.payload)),
+			app.Button().Text("publish").OnClick(i.onPublish),
+			app.Button().Text("request").OnClick(i.onRequest),
+			app.Pre().Class("natsws-inspector-response").Text(i.response),
+		),
+		app.Table().Class("natsws-inspector-subscriptions").Body(
+			app.Tr().Body(app.Th().Text("subject"), app.Th().Text("queue"),
+				app.Th().Text("received"), app.Th().Text("dropped")),
+			app.Range(subs).Slice(func(n int) app.UI { return subs[n] }),
+		),
+		app.Input().Placeholder("filter subjects").Value(i.filter).OnInput(i.ValueTo(&i.filter)),
+		app.Button().Text("clear").OnClick(func(ctx app.Context, e app.Event) { i.events = nil }),
+		app.Div().Class("natsws-inspector-events").Body(
+			app.Range(rows).Slice(func(n int) app.UI { return rows[n] }),
+		),
+	)
+}
+
+func (i *Inspector) renderEvent(event TrafficEvent) app.UI {
+	direction := "in"
+	if event.Outgoing {
+		direction = "out"
+	}
+	summary := fmt.Sprintf("%s %s %s %s %d bytes", event.Time.Format("15:04:05.000"),
+		direction, event.Kind, event.Subject, len(event.Data))
+	if event.Err != nil {
+		summary += " error " + event.Err.Error()
+	}
+
+	var headers []string
+	for key, values := range event.Header {
+		headers = append(headers, key+": "+strings.Join(values, ", "))
+	}
+
+	return app.Details().Class("natsws-inspector-event", "natsws-inspector-"+direction).Body(
+		app.Summary().Text(summary),
+		app.If(len(headers) > 0, app.Pre().Text(strings.Join(headers, "\n"))),
+		app.Pre().Text(preview(event.Data)),
+	)
+}
+
+// preview pretty prints JSON payloads and truncates long payloads.
+func preview(data []byte) string {
+	var indented bytes.Buffer
+	if json.Valid(data) && json.Indent(&indented, data, "", "  ") == nil {
+		data = indented.Bytes()
+	}
+	if len(data) > inspectorPreview {
+		return string(data[:inspectorPreview]) + "\n..."
+	}
+	return string(data)
+}
+
+func (i *Inspector) onPublish(ctx app.Context, e app.Event) {
+	if err := i.conn.PublishMsg(&nats.Msg{Subject: i.subject, Data: []byte(i.payload)}); err != nil {
+		i.response = err.Error()
+	}
+}
+
+func (i *Inspector) onRequest(ctx app.Context, e app.Event) {
+	msg := &nats.Msg{Subject: i.subject, Data: []byte(i.payload)}
+	ctx.Async(func() {
+		response, err := i.conn.RequestMsg(msg, inspectorTimeout)
+		ctx.Dispatch(func(ctx app.Context) {
+			if err != nil {
+				i.response = err.Error()
+				return
+			}
+			i.response = preview(response.Data)
+		})
+	})
+}
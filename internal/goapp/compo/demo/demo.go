@@ -10,11 +10,13 @@ import (
 )
 
 var _ app.Mounter = (*Demo)(nil)
+var _ app.Dismounter = (*Demo)(nil)
 
 type Demo struct {
 	app.Compo
-	messages []string
-	conn     natsws.Connection
+	messages    []string
+	conn        natsws.Connection
+	unsubscribe func()
 }
 
 const Subject = "testSubject"
@@ -23,9 +25,9 @@ func (d *Demo) OnMount(ctx app.Context) {
 	natsws.Observe(ctx, &d.conn).OnChange(func() {
 		// clients should subscribe only ChangeReason Connect to avoid creating duplicate subscriptions
 		if d.conn.ChangeReason() == natsws.Connect {
-			err := d.conn.Subscribe(Subject, func(msg *nats.Msg) {
+			var err error
+			d.unsubscribe, err = d.conn.SubscribeDispatch(ctx, Subject, func(ctx app.Context, msg *nats.Msg) {
 				d.messages = append(d.messages, string(msg.Data))
-				d.Update()
 			})
 			if err != nil {
 				fmt.Println("subscribe error", err)
@@ -36,6 +38,12 @@ func (d *Demo) OnMount(ctx app.Context) {
 	})
 }
 
+func (d *Demo) OnDismount() {
+	if d.unsubscribe != nil {
+		d.unsubscribe()
+	}
+}
+
 func (d *Demo) Render() app.UI {
 
 	var reversed []string
@@ -45,9 +53,18 @@ func (d *Demo) Render() app.UI {
 		reversed[i], reversed[j] = reversed[j], reversed[i]
 	}
 
+	lastError := "none"
+	if err := d.conn.LastError(); err != nil {
+		lastError = err.Error()
+	}
+
 	return app.Div().Body(
 		app.Text("changeReason "+d.conn.ChangeReason()),
 		app.Br(),
+		app.Text("lastError "+lastError),
+		app.Br(),
+		app.Text(fmt.Sprintf("latency %s average %s", d.conn.Latency(), d.conn.AverageLatency())),
+		app.Br(),
 		app.If(app.Getenv(natsws.UseDialer) == "",
 			app.Button().Text("disconnect").OnClick(func(ctx app.Context, e app.Event) {
 				if conn, err := d.conn.Nats(); err == nil {
@@ -57,7 +74,8 @@ func (d *Demo) Render() app.UI {
 		),
 		app.Br(),
 		app.Button().Text("publish").OnClick(func(ctx app.Context, e app.Event) {
-			err := d.conn.Publish(Subject, []byte(time.Now().String()))
+			msg := natsws.NewMsg(Subject, []byte(time.Now().String()), natsws.ContentTypeHeader, "text/plain")
+			err := d.conn.PublishMsg(msg)
 			if err != nil {
 				app.Log("publish error", err)
 			}
@@ -4,6 +4,7 @@ import (
 	"github.com/maxence-charriere/go-app/v9/pkg/app"
 	"github.com/mlctrez/goapp-natsws"
 	"github.com/mlctrez/goapp-natsws/internal/goapp/compo/demo"
+	"time"
 )
 
 var _ app.AppUpdater = (*Root)(nil)
@@ -14,8 +15,12 @@ type Root struct {
 
 func (r *Root) Render() app.UI {
 	return app.Div().Body(
-		&natsws.Component{},
+		&natsws.Component{Options: natsws.Options{
+			MaxReconnectWait: 30 * time.Second, PauseWhenOffline: true, SuspendWhenHidden: time.Minute,
+		}},
+		&natsws.Status{},
 		&demo.Demo{},
+		&natsws.Inspector{},
 	)
 }
 
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/mlctrez/goapp-natsws"
+	"github.com/nats-io/nats.go"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// loadtest connects headless clients to a natsws.Proxy, each publishes to subject and counts
+// the messages all clients receive.
+//
+//	go run ./loadtest -url ws://localhost:8080/natsws/ -clients 50 -messages 100
+func main() {
+	wsUrl := flag.String("url", "ws://localhost:8080/natsws/", "websocket url of the proxy including the path prefix")
+	clients := flag.Int("clients", 10, "number of clients")
+	messages := flag.Int("messages", 100, "messages published by each client")
+	subject := flag.String("subject", "natsws.loadtest", "subject to publish and subscribe to")
+	timeout := flag.Duration("timeout", 30*time.Second, "time to connect and deliver all messages")
+	flag.Parse()
+
+	if err := run(*wsUrl, *clients, *messages, *subject, *timeout); err != nil {
+		fmt.Println("loadtest:", err)
+		os.Exit(1)
+	}
+}
+
+func run(wsUrl string, clients, messages int, subject string, timeout time.Duration) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var received int64
+	conns := make([]*natsws.Connection, clients)
+	for i := range conns {
+		dialer := &natsws.Dialer{URL: wsUrl, ClientName: fmt.Sprintf("loadtest-%d", i)}
+		if conns[i], err = dialer.Dial(ctx); err != nil {
+			return
+		}
+		if err = conns[i].WaitConnected(ctx); err != nil {
+			return
+		}
+		if _, err = conns[i].Subscribe(subject, func(msg *nats.Msg) { atomic.AddInt64(&received, 1) }); err != nil {
+			return
+		}
+	}
+	defer func() {
+		for _, conn := range conns {
+			_ = conn.Drain(time.Second)
+		}
+	}()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, conn := range conns {
+		wg.Add(1)
+		go func(conn *natsws.Connection) {
+			defer wg.Done()
+			for i := 0; i < messages; i++ {
+				_ = conn.Publish(subject, []byte(time.Now().Format(time.RFC3339Nano)))
+			}
+		}(conn)
+	}
+	wg.Wait()
+
+	expected := int64(clients * clients * messages)
+	for atomic.LoadInt64(&received) < expected {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("received %d of %d messages: %w", atomic.LoadInt64(&received), expected, ctx.Err())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	elapsed := time.Since(start)
+	fmt.Printf("delivered %d messages in %s, %.0f msgs/sec\n", expected, elapsed, float64(expected)/elapsed.Seconds())
+	return
+}
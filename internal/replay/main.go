@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/mlctrez/goapp-natsws"
+	"net/http"
+	"os"
+)
+
+// replay drives a session recorded by natsws.Proxy.
+//
+//	go run ./replay -file session.natsws -url ws://localhost:8100
+//	go run ./replay -file session.natsws -mode server -listen localhost:8200
+func main() {
+	file := flag.String("file", "", "recording written by natsws.FileRecorder")
+	mode := flag.String("mode", "client", "client replays the client stream to -url, server plays the backend stream to clients of -listen")
+	wsUrl := flag.String("url", "", "nats websocket url for client mode")
+	listen := flag.String("listen", "localhost:8200", "listen address for server mode")
+	realtime := flag.Bool("realtime", true, "preserve the recorded spacing between frames")
+	flag.Parse()
+
+	if err := run(*file, *mode, *wsUrl, *listen, *realtime); err != nil {
+		fmt.Println("replay:", err)
+		os.Exit(1)
+	}
+}
+
+func run(file, mode, wsUrl, listen string, realtime bool) (err error) {
+	var reader *os.File
+	if reader, err = os.Open(file); err != nil {
+		return
+	}
+	defer func() { _ = reader.Close() }()
+
+	var frames []natsws.RecordedFrame
+	if frames, err = natsws.ReadRecording(reader); err != nil {
+		return
+	}
+
+	switch mode {
+	case "client":
+		return natsws.ReplayClient(context.Background(), wsUrl, frames, realtime)
+	case "server":
+		fmt.Printf("replaying %d frames to websocket clients on ws://%s\n", len(frames), listen)
+		return http.ListenAndServe(listen, &natsws.ReplayServer{Frames: frames, Realtime: realtime})
+	default:
+		return fmt.Errorf("unknown mode %q", mode)
+	}
+}
@@ -0,0 +1,186 @@
+package natsws
+
+import (
+	"errors"
+	"github.com/nats-io/nats.go"
+	"sync"
+	"time"
+)
+
+// cursorSaveInterval limits how often a Consumer persists its resume cursor.
+const cursorSaveInterval = time.Second
+
+// ConsumeOptions configure a JetStream consumer created with Connection.Consume.
+type ConsumeOptions struct {
+	// Stream binds the consumer to a stream, it is optional when the subject belongs to a single stream.
+	Stream string
+	// Durable names a durable consumer that tracks acknowledgements on the server.
+	// Without Durable an ephemeral ordered consumer is used, which needs no acknowledgements.
+	Durable string
+	// StartSequence replays the stream from this sequence.
+	StartSequence uint64
+	// StartTime replays the stream from this time when StartSequence is not set.
+	// Without a start only new messages are delivered.
+	StartTime time.Time
+	// ManualAck leaves acknowledging to the handler of a durable consumer,
+	// by default messages are acknowledged after the handler returns.
+	ManualAck bool
+	// Resume persists the stream sequence of the last handled message in local storage,
+	// keyed by the client name, and continues after it when an ordered consumer is
+	// created again, for example after a page reload or when the connection is replaced.
+	// The sequence is written at most once per second, when the Connection stops and
+	// when the page is hidden.
+	Resume bool
+}
+
+// Consumer is a JetStream consumer of a Connection.
+type Consumer struct {
+	conn      *Connection
+	subject   string
+	options   ConsumeOptions
+	handler   nats.MsgHandler
+	cursorKey string
+	sub       *subscription
+
+	mu        sync.Mutex
+	cursor    uint64
+	saved     uint64
+	saveTimer *time.Timer
+}
+
+// Consume creates a JetStream consumer for subject that calls cb for each message.
+//
+// The consumer is recreated like other subscriptions when the Connection replaces its
+// nats connection, with Options.Resume it continues after the last handled message.
+func (c *Connection) Consume(subject string, options ConsumeOptions, cb nats.MsgHandler) (consumer *Consumer, err error) {
+	consumer = &Consumer{conn: c, subject: subject, options: options, handler: c.tracer.tracedHandler(cb)}
+	if options.Resume {
+		name := options.Durable
+		if name == "" {
+			name = options.Stream + ":" + subject
+		}
+		consumer.cursorKey = StateKey(c.name) + ".cursor." + c.ClientName() + "." + name
+		c.binding.getState(consumer.cursorKey, &consumer.cursor)
+		consumer.saved = consumer.cursor
+	}
+
+	consumer.sub = &subscription{subject: subject, custom: consumer.subscribe}
+	if options.Resume {
+		consumer.sub.persist = consumer.saveCursor
+	}
+	if err = c.addSubscription(consumer.sub); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Stop removes the consumer from the Connection and saves its cursor,
+// a durable consumer remains on the server.
+func (c *Consumer) Stop() error {
+	return c.conn.removeSubscription(c.sub)
+}
+
+func (c *Consumer) subscribe(conn *nats.Conn) (sub *nats.Subscription, err error) {
+	var js nats.JetStreamContext
+	if js, err = conn.JetStream(); err != nil {
+		return
+	}
+
+	if c.options.Durable != "" {
+		// a durable consumer resumes from its acknowledgements on the server
+		var stream string
+		if stream, err = c.ensureDurable(js); err != nil {
+			return
+		}
+		return js.Subscribe(c.subject, c.handle, nats.Bind(stream, c.options.Durable), nats.ManualAck())
+	}
+
+	opts := []nats.SubOpt{nats.OrderedConsumer()}
+	if c.options.Stream != "" {
+		opts = append(opts, nats.BindStream(c.options.Stream))
+	}
+	switch cursor := c.Cursor(); {
+	case cursor > 0:
+		opts = append(opts, nats.StartSequence(cursor+1))
+	case c.options.StartSequence > 0:
+		opts = append(opts, nats.StartSequence(c.options.StartSequence))
+	case !c.options.StartTime.IsZero():
+		opts = append(opts, nats.StartTime(c.options.StartTime))
+	default:
+		opts = append(opts, nats.DeliverNew())
+	}
+	return js.Subscribe(c.subject, c.handle, opts...)
+}
+
+// ensureDurable creates the durable consumer when it does not exist. Subscriptions bind
+// to it instead of letting nats.go create it, which would delete it on unsubscribe.
+func (c *Consumer) ensureDurable(js nats.JetStreamContext) (stream string, err error) {
+	if stream = c.options.Stream; stream == "" {
+		if stream, err = js.StreamNameBySubject(c.subject); err != nil {
+			return
+		}
+	}
+
+	if _, err = js.ConsumerInfo(stream, c.options.Durable); !errors.Is(err, nats.ErrConsumerNotFound) {
+		return
+	}
+
+	config := &nats.ConsumerConfig{
+		Durable:        c.options.Durable,
+		FilterSubject:  c.subject,
+		DeliverSubject: nats.NewInbox(),
+		AckPolicy:      nats.AckExplicitPolicy,
+		DeliverPolicy:  nats.DeliverNewPolicy,
+	}
+	switch {
+	case c.options.StartSequence > 0:
+		config.DeliverPolicy = nats.DeliverByStartSequencePolicy
+		config.OptStartSeq = c.options.StartSequence
+	case !c.options.StartTime.IsZero():
+		config.DeliverPolicy = nats.DeliverByStartTimePolicy
+		config.OptStartTime = &c.options.StartTime
+	}
+	_, err = js.AddConsumer(stream, config)
+	return
+}
+
+func (c *Consumer) handle(msg *nats.Msg) {
+	c.handler(msg)
+
+	if c.options.Durable != "" && !c.options.ManualAck {
+		_ = msg.Ack()
+	}
+
+	meta, err := msg.Metadata()
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	c.cursor = meta.Sequence.Stream
+	if c.cursorKey != "" && c.saveTimer == nil {
+		c.saveTimer = time.AfterFunc(cursorSaveInterval, c.saveCursor)
+	}
+	c.mu.Unlock()
+}
+
+// saveCursor persists the cursor when it changed since it was last saved.
+func (c *Consumer) saveCursor() {
+	c.mu.Lock()
+	if c.saveTimer != nil {
+		c.saveTimer.Stop()
+		c.saveTimer = nil
+	}
+	cursor, changed := c.cursor, c.cursor != c.saved
+	c.saved = c.cursor
+	c.mu.Unlock()
+	if changed {
+		c.conn.binding.setState(c.cursorKey, cursor, true)
+	}
+}
+
+// Cursor returns the stream sequence of the last handled message.
+func (c *Consumer) Cursor() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cursor
+}
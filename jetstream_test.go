@@ -0,0 +1,175 @@
+//go:build !wasm
+
+package natsws
+
+import (
+	"context"
+	"github.com/nats-io/nats.go"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dialJetStream dials a Connection and returns a backend JetStream context with stream.
+func dialJetStream(t *testing.T, stream string) (tc *testConnection, js nats.JetStreamContext) {
+	tc = newTestConnection(t)
+	_, js = tc.backend(t)
+	if _, err := js.AddStream(&nats.StreamConfig{Name: stream, Subjects: []string{stream + ".>"}}); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func receive(t *testing.T, ctx context.Context, received chan *nats.Msg, want ...string) {
+	t.Helper()
+	for _, data := range want {
+		select {
+		case msg := <-received:
+			if string(msg.Data) != data {
+				t.Fatalf("expected %q, got %q", data, msg.Data)
+			}
+		case <-ctx.Done():
+			t.Fatalf("message %q not received", data)
+		}
+	}
+}
+
+func publish(t *testing.T, js nats.JetStreamContext, subject string, from, to int) {
+	t.Helper()
+	for i := from; i <= to; i++ {
+		if _, err := js.Publish(subject, []byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestConsumeDurable(t *testing.T) {
+	tc, js := dialJetStream(t, "orders")
+	conn, ctx := tc.Connection, tc.ctx
+
+	received := make(chan *nats.Msg, 16)
+	if _, err := conn.Consume("orders.created", ConsumeOptions{Durable: "billing"}, func(msg *nats.Msg) {
+		received <- msg
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	publish(t, js, "orders.created", 1, 3)
+	receive(t, ctx, received, "1", "2", "3")
+	waitFor(t, ctx, "acknowledgements", func() bool {
+		info, err := js.ConsumerInfo("orders", "billing")
+		return err == nil && info.AckFloor.Stream == 3
+	})
+
+	// the durable consumer survives the replaced nats connection and continues after the acknowledged messages
+	previous := conn.natsConnection()
+	conn.ReconnectNow()
+	waitFor(t, ctx, "reconnect", func() bool {
+		current := conn.natsConnection()
+		return current != nil && current != previous && current.IsConnected()
+	})
+	waitFor(t, ctx, "subscription", func() bool {
+		info, err := js.ConsumerInfo("orders", "billing")
+		return err == nil && info.PushBound
+	})
+	publish(t, js, "orders.created", 4, 4)
+	receive(t, ctx, received, "4")
+	select {
+	case msg := <-received:
+		t.Fatalf("unexpected redelivery %q", msg.Data)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, err := js.ConsumerInfo("orders", "billing"); err != nil {
+		t.Fatalf("expected the durable consumer to remain, got %v", err)
+	}
+}
+
+func TestConsumeRetryAndStop(t *testing.T) {
+	tc, js := dialJetStream(t, "orders")
+	conn, ctx := tc.Connection, tc.ctx
+
+	received := make(chan *nats.Msg, 16)
+	consumer, err := conn.Consume("orders.created", ConsumeOptions{Durable: "shipping"}, func(msg *nats.Msg) {
+		received <- msg
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the consumer cannot be recreated without its stream, the failure is reported
+	if err = js.DeleteStream("orders"); err != nil {
+		t.Fatal(err)
+	}
+	conn.ReconnectNow()
+	waitFor(t, ctx, "resubscribe error", func() bool {
+		lastErr := conn.LastError()
+		return lastErr != nil && strings.Contains(lastErr.Error(), `subscription "orders.created"`)
+	})
+
+	// and retried on the next connect
+	if _, err = js.AddStream(&nats.StreamConfig{Name: "orders", Subjects: []string{"orders.>"}}); err != nil {
+		t.Fatal(err)
+	}
+	conn.ReconnectNow()
+	waitFor(t, ctx, "subscription", func() bool {
+		info, infoErr := js.ConsumerInfo("orders", "shipping")
+		return infoErr == nil && info.PushBound
+	})
+	publish(t, js, "orders.created", 1, 1)
+	receive(t, ctx, received, "1")
+
+	if err = consumer.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if infos := conn.Subscriptions(); len(infos) != 0 {
+		t.Fatalf("expected no subscriptions after Stop, got %+v", infos)
+	}
+	waitFor(t, ctx, "unbind", func() bool {
+		info, infoErr := js.ConsumerInfo("orders", "shipping")
+		return infoErr == nil && !info.PushBound
+	})
+}
+
+func TestConsumeResume(t *testing.T) {
+	tc, js := dialJetStream(t, "events")
+	conn, ctx := tc.Connection, tc.ctx
+
+	received := make(chan *nats.Msg, 16)
+	consumer, err := conn.Consume("events.clicked", ConsumeOptions{Resume: true}, func(msg *nats.Msg) {
+		received <- msg
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publish(t, js, "events.clicked", 1, 2)
+	receive(t, ctx, received, "1", "2")
+	waitFor(t, ctx, "cursor", func() bool { return consumer.Cursor() == 2 })
+
+	var saved uint64
+	conn.binding.getState(consumer.cursorKey, &saved)
+	if saved != 0 {
+		t.Fatalf("expected the cursor write to wait for cursorSaveInterval, got %d", saved)
+	}
+
+	// the ordered consumer continues after the cursor on the replaced nats connection
+	conn.ReconnectNow()
+	publish(t, js, "events.clicked", 3, 3)
+	receive(t, ctx, received, "3")
+	select {
+	case msg := <-received:
+		t.Fatalf("unexpected redelivery %q", msg.Data)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// stopping the Connection persists the cursor without waiting
+	waitFor(t, ctx, "cursor", func() bool { return consumer.Cursor() == 3 })
+	if err = conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	conn.binding.getState(consumer.cursorKey, &saved)
+	if saved != 3 {
+		t.Fatalf("expected cursor 3 to be persisted, got %d", saved)
+	}
+}
@@ -0,0 +1,178 @@
+package natsws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/maxence-charriere/go-app/v9/pkg/app"
+	"github.com/nats-io/nats.go"
+	"sync"
+	"time"
+)
+
+// ErrConflict is returned by KeyValueBinding.SetState when the key was changed by another writer.
+var ErrConflict = errors.New("natsws: key value revision conflict")
+
+const keyValueRetry = time.Second
+
+// JetStream returns a JetStream context for the nats connection.
+// It returns ErrRelayed in a tab relaying through another tab.
+func (c *Connection) JetStream(opts ...nats.JSOpt) (js nats.JetStreamContext, err error) {
+	if c.relay.isFollower() {
+		return nil, ErrRelayed
+	}
+	var conn *nats.Conn
+	if conn, err = c.Nats(); err != nil {
+		return
+	}
+	return conn.JetStream(opts...)
+}
+
+// KeyValueBinding binds a go-app state key to a key of a JetStream KeyValue bucket.
+//
+// Values are stored as JSON. Changes of the key, including those made by other
+// users, are applied with ctx.SetState so components observe them like any other state.
+type KeyValueBinding[T any] struct {
+	// OnConflict is called on the UI goroutine when SetState lost a write to another writer.
+	OnConflict func(ctx app.Context, err error)
+	// OnError is called on the UI goroutine for watch and decode errors.
+	OnError func(ctx app.Context, err error)
+
+	ctx      app.Context
+	conn     *Connection
+	bucket   string
+	key      string
+	stateKey string
+
+	mu       sync.Mutex
+	revision uint64
+}
+
+// BindKeyValue watches key of bucket and stores its value in the go-app state stateKey
+// until ctx is done. Pass the observed Connection of the component, it must not be nil.
+func BindKeyValue[T any](ctx app.Context, conn *Connection, bucket, key, stateKey string) *KeyValueBinding[T] {
+	b := &KeyValueBinding[T]{ctx: ctx, conn: conn, bucket: bucket, key: key, stateKey: stateKey}
+	ctx.Async(b.run)
+	return b
+}
+
+func (b *KeyValueBinding[T]) keyValue() (kv nats.KeyValue, err error) {
+	var js nats.JetStreamContext
+	if js, err = b.conn.JetStream(); err != nil {
+		return
+	}
+	return js.KeyValue(b.bucket)
+}
+
+// run restarts the watch whenever the nats connection is replaced or not yet connected.
+// A tab relaying through another tab has no JetStream, it reports ErrRelayed once.
+func (b *KeyValueBinding[T]) run() {
+	for {
+		err := b.watch()
+		if errors.Is(err, ErrRelayed) {
+			b.report(b.OnError, err)
+			return
+		}
+		if err != nil && b.conn.IsConnected() {
+			b.report(b.OnError, err)
+		}
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-time.After(keyValueRetry):
+		}
+	}
+}
+
+func (b *KeyValueBinding[T]) watch() (err error) {
+	var kv nats.KeyValue
+	if kv, err = b.keyValue(); err != nil {
+		return
+	}
+
+	var watcher nats.KeyWatcher
+	if watcher, err = kv.Watch(b.key, nats.Context(b.ctx)); err != nil {
+		return
+	}
+	defer func() { _ = watcher.Stop() }()
+
+	// the updates channel is closed when the watcher or the nats connection stops
+	for entry := range watcher.Updates() {
+		if entry == nil {
+			// all initial values have been received
+			continue
+		}
+		b.apply(entry)
+	}
+	return
+}
+
+func (b *KeyValueBinding[T]) apply(entry nats.KeyValueEntry) {
+	b.mu.Lock()
+	b.revision = entry.Revision()
+	b.mu.Unlock()
+
+	var value T
+	if entry.Operation() == nats.KeyValuePut {
+		if err := json.Unmarshal(entry.Value(), &value); err != nil {
+			b.report(b.OnError, fmt.Errorf("natsws: decode %s.%s: %w", b.bucket, b.key, err))
+			return
+		}
+	}
+	b.ctx.SetState(b.stateKey, value)
+}
+
+// SetState writes value to the bucket if the key has not changed since the last
+// revision seen by the binding, then stores it in the go-app state with opts.
+//
+// When another writer changed the key first, ErrConflict is returned, OnConflict is
+// called and the state is left to the watch, which delivers the winning value.
+func (b *KeyValueBinding[T]) SetState(value T, opts ...app.StateOption) (err error) {
+	var data []byte
+	if data, err = json.Marshal(value); err != nil {
+		return
+	}
+
+	var kv nats.KeyValue
+	if kv, err = b.keyValue(); err != nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var revision uint64
+	if b.revision == 0 {
+		revision, err = kv.Create(b.key, data)
+	} else {
+		revision, err = kv.Update(b.key, data, b.revision)
+	}
+	if err != nil {
+		var apiErr *nats.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode == nats.JSErrCodeStreamWrongLastSequence {
+			err = fmt.Errorf("%w: %s.%s revision %d", ErrConflict, b.bucket, b.key, b.revision)
+			b.report(b.OnConflict, err)
+		}
+		return
+	}
+
+	b.revision = revision
+	b.ctx.SetState(b.stateKey, value, opts...)
+	return
+}
+
+// Revision returns the bucket revision of the current value.
+func (b *KeyValueBinding[T]) Revision() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.revision
+}
+
+func (b *KeyValueBinding[T]) report(handler func(ctx app.Context, err error), err error) {
+	if handler == nil {
+		return
+	}
+	b.ctx.Dispatch(func(ctx app.Context) {
+		handler(ctx, err)
+	})
+}
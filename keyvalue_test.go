@@ -0,0 +1,82 @@
+//go:build !wasm
+
+package natsws
+
+import (
+	"errors"
+	"github.com/maxence-charriere/go-app/v9/pkg/app"
+	"github.com/nats-io/nats.go"
+	"testing"
+	"time"
+)
+
+func TestKeyValueBinding(t *testing.T) {
+	tc := newTestConnection(t)
+	conn, ctx := tc.Connection, tc.ctx
+	_, js := tc.backend(t)
+	kv, err := js.CreateKeyValue(&nats.KeyValueConfig{Bucket: "settings"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = kv.Put("theme", []byte(`"dark"`)); err != nil {
+		t.Fatal(err)
+	}
+
+	compo := &testCompo{}
+	dispatcher := app.NewClientTester(compo)
+	defer dispatcher.Close()
+
+	binding := BindKeyValue[string](asyncContext{Context: compo.ctx, ctx: ctx}, conn, "settings", "theme", "theme")
+	var conflicts []error
+	binding.OnConflict = func(ctx app.Context, err error) { conflicts = append(conflicts, err) }
+
+	waitState := func(want string) {
+		t.Helper()
+		for {
+			var value string
+			dispatcher.GetState("theme", &value)
+			if value == want {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				t.Fatalf("state %q not received, got %q", want, value)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+	waitState("dark")
+
+	// another writer changes the key after the binding read it
+	stale := binding.Revision()
+	revision, err := kv.Update("theme", []byte(`"light"`), stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitState("light")
+	if binding.Revision() != revision {
+		t.Fatalf("expected revision %d, got %d", revision, binding.Revision())
+	}
+	binding.mu.Lock()
+	binding.revision = stale
+	binding.mu.Unlock()
+	if err = binding.SetState("blue"); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+	if len(conflicts) != 1 || !errors.Is(conflicts[0], ErrConflict) {
+		t.Fatalf("expected one conflict, got %v", conflicts)
+	}
+
+	// the watch resumes on the replaced nats connection
+	conn.ReconnectNow()
+	if _, err = kv.Put("theme", []byte(`"green"`)); err != nil {
+		t.Fatal(err)
+	}
+	waitState("green")
+	if err = binding.SetState("red"); err != nil {
+		t.Fatalf("expected a write at the resumed revision, got %v", err)
+	}
+	if entry, err := kv.Get("theme"); err != nil || string(entry.Value()) != `"red"` {
+		t.Fatalf("unexpected entry %v %v", entry, err)
+	}
+}
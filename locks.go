@@ -0,0 +1,8 @@
+//go:build !wasm
+
+package natsws
+
+// requestLeaderLock is only available in the browser.
+func requestLeaderLock(_ string, _ func()) (release func(), ok bool) {
+	return nil, false
+}
@@ -0,0 +1,40 @@
+package natsws
+
+import (
+	"syscall/js"
+)
+
+// requestLeaderLock requests the Web Lock name and calls acquired once this tab holds it.
+// The lock is held until release is called or the tab is closed.
+func requestLeaderLock(name string, acquired func()) (release func(), ok bool) {
+	locks := js.Global().Get("navigator").Get("locks")
+	if !locks.Truthy() {
+		return nil, false
+	}
+
+	var resolve js.Value
+	executor := js.FuncOf(func(this js.Value, args []js.Value) any {
+		resolve = args[0]
+		return nil
+	})
+	callback := js.FuncOf(func(this js.Value, args []js.Value) any {
+		acquired()
+		// the lock is held until the returned promise resolves
+		return js.Global().Get("Promise").New(executor)
+	})
+	ignore := js.FuncOf(func(this js.Value, args []js.Value) any { return nil })
+
+	controller := js.Global().Get("AbortController").New()
+	options := map[string]any{"signal": controller.Get("signal")}
+	locks.Call("request", name, options, callback).Call("catch", ignore)
+
+	return func() {
+		if resolve.Truthy() {
+			resolve.Invoke()
+		} else {
+			controller.Call("abort")
+		}
+		callback.Release()
+		executor.Release()
+	}, true
+}
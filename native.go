@@ -0,0 +1,130 @@
+package natsws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/nats-io/nats.go"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+var errDialerUrl = errors.New("natsws: Dialer.URL is required")
+
+const waitConnectedPoll = 10 * time.Millisecond
+
+// Dialer connects to the websocket endpoint of a Proxy from a plain Go program, for example a
+// headless load test, a bot or a server side test. It is the counterpart of Component and the
+// Connection it returns has the same API as in the browser.
+type Dialer struct {
+	// URL is the websocket url of the Proxy including the path prefix, for example ws://localhost:8080/natsws/.
+	URL string
+	// Name distinguishes multiple connections, it is the Name of the Connection.
+	Name string
+	// ClientName sets the client name, a UUID is generated when empty.
+	ClientName string
+	// ClientNameMode ServerClientName requests the client name from the Proxy,
+	// other modes generate a UUID when ClientName is empty.
+	ClientNameMode ClientNameMode
+	// Tracer, Options and NatsOptions configure the Connection, see Options.
+	Tracer      *Tracer
+	Options     Options
+	NatsOptions []nats.Option
+	// OnChange is called after each change of the Connection with its ChangeReason, like an
+	// observer of a Component it receives a copy of the Connection that does not change.
+	// It is called on nats.go and Connection goroutines.
+	OnChange func(conn *Connection)
+}
+
+// Dial starts a Connection that runs until ctx is done or it is closed. Like the Connection
+// of a Component it connects and reconnects in the background, use WaitConnected to wait
+// for the first connect.
+func (d *Dialer) Dial(ctx context.Context) (conn *Connection, err error) {
+	if d.URL == "" {
+		return nil, errDialerUrl
+	}
+	conn = newConnection(&nativeBinding{ctx: ctx, onChange: d.OnChange, state: map[string][]byte{}}, d.Name)
+	conn.url = d.URL
+	conn.clientName = d.ClientName
+	conn.clientNameMode = d.ClientNameMode
+	conn.natsOptions = d.NatsOptions
+	conn.tracer = d.Tracer
+	conn.options = d.Options
+	go conn.run()
+	return
+}
+
+// WaitConnected waits until the Connection is connected or ctx is done.
+func (c *Connection) WaitConnected(ctx context.Context) error {
+	ticker := time.NewTicker(waitConnectedPoll)
+	defer ticker.Stop()
+	for !c.IsConnected() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+var _ binding = (*nativeBinding)(nil)
+
+// nativeBinding binds a Connection to a plain Go program, state is kept in memory as JSON
+// like the go-app state, so it does not survive the program.
+type nativeBinding struct {
+	ctx      context.Context
+	onChange func(conn *Connection)
+
+	mu    sync.Mutex
+	state map[string][]byte
+}
+
+func (b *nativeBinding) context() context.Context {
+	return b.ctx
+}
+
+func (b *nativeBinding) notify(c *Connection) {
+	if b.onChange != nil {
+		b.onChange(c)
+	}
+}
+
+func (b *nativeBinding) setState(key string, value any, _ bool) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state[key] = data
+}
+
+func (b *nativeBinding) getState(key string, value any) {
+	b.mu.Lock()
+	data := b.state[key]
+	b.mu.Unlock()
+	if data != nil {
+		_ = json.Unmarshal(data, value)
+	}
+}
+
+// setSession shares the state, a program has a single session.
+func (b *nativeBinding) setSession(key string, value any) {
+	b.setState(key, value, false)
+}
+
+func (b *nativeBinding) getSession(key string, value any) {
+	b.getState(key, value)
+}
+
+func (b *nativeBinding) getenv(key string) string {
+	return os.Getenv(key)
+}
+
+// windowUrl is not used, Dial requires Dialer.URL.
+func (b *nativeBinding) windowUrl() *url.URL {
+	return &url.URL{}
+}
@@ -0,0 +1,225 @@
+//go:build !wasm
+
+package natsws
+
+import (
+	"errors"
+	"github.com/nats-io/nats.go"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDialer(t *testing.T) {
+	var mu sync.Mutex
+	var reasons []ChangeReason
+	tc := newTestConnection(t, func(_ *Proxy, dialer *Dialer) {
+		dialer.ClientName = "bot"
+		dialer.OnChange = func(conn *Connection) {
+			mu.Lock()
+			defer mu.Unlock()
+			reasons = append(reasons, conn.ChangeReason())
+		}
+	})
+	conn, ctx := tc.Connection, tc.ctx
+	if conn.ClientName() != "bot" || !strings.HasSuffix(strings.Split(conn.ConnectedUrl(), "?")[0], "/natsws/bot") {
+		t.Fatalf("unexpected client %q url %q", conn.ClientName(), conn.ConnectedUrl())
+	}
+
+	received := make(chan *nats.Msg, 1)
+	if _, err := conn.Subscribe("bot.echo", func(msg *nats.Msg) {
+		received <- msg
+		_ = msg.Respond(msg.Data)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := conn.Publish("bot.echo", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-received:
+		if string(msg.Data) != "hello" {
+			t.Fatalf("unexpected message %q", msg.Data)
+		}
+	case <-ctx.Done():
+		t.Fatal("message not received")
+	}
+
+	response, err := conn.Request("bot.echo", []byte("ping"), time.Second)
+	if err != nil || string(response.Data) != "ping" {
+		t.Fatalf("unexpected response %v %v", response, err)
+	}
+
+	if stats := conn.Stats(); stats.OutMsgs < 2 || len(stats.Subscriptions) != 1 || stats.Subscriptions[0].Received != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	if err = conn.Drain(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if conn.IsConnected() || conn.ChangeReason() != Closed {
+		t.Fatalf("expected closed connection, got %s", conn.ChangeReason())
+	}
+	if err = conn.Close(); err != nats.ErrConnectionClosed {
+		t.Fatalf("expected ErrConnectionClosed closing twice, got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reasons) == 0 || reasons[0] != Connect || reasons[len(reasons)-1] != Closed {
+		t.Fatalf("unexpected change reasons %v", reasons)
+	}
+}
+
+func TestChanSubscribeSlowConsumer(t *testing.T) {
+	tc := newTestConnection(t)
+	conn := tc.Connection
+
+	ch := make(chan *nats.Msg, 1)
+	unsubscribe, err := conn.ChanSubscribe("slow.jobs", "", ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err = conn.Publish("slow.jobs", []byte("job")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// nats.go reports the messages it could not deliver to ch as a slow consumer
+	waitFor(t, tc.ctx, "slow consumer error", func() bool { return errors.Is(conn.LastError(), nats.ErrSlowConsumer) })
+	if infos := conn.Subscriptions(); len(infos) != 1 || infos[0].Pending != 1 || infos[0].Dropped != 4 {
+		t.Fatalf("unexpected subscription info %+v", infos)
+	}
+
+	unsubscribe()
+	if infos := conn.Subscriptions(); len(infos) != 0 {
+		t.Fatalf("expected no subscriptions after unsubscribe, got %+v", infos)
+	}
+}
+
+func TestSubscribeSyncUnsubscribe(t *testing.T) {
+	tc := newTestConnection(t)
+	conn, ctx := tc.Connection, tc.ctx
+
+	sub, err := conn.SubscribeSync("sync.jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := conn.SubscribeSync("sync.other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.Publish("sync.jobs", []byte("job")); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := sub.NextMsg(ctx); err != nil || string(msg.Data) != "job" {
+		t.Fatalf("unexpected message %v %v", msg, err)
+	}
+
+	if err = sub.Unsubscribe(); err != nil {
+		t.Fatal(err)
+	}
+	for range sub.Messages() {
+		t.Fatal("unexpected message after Unsubscribe")
+	}
+	if _, err = sub.NextMsg(ctx); err != nats.ErrBadSubscription {
+		t.Fatalf("expected ErrBadSubscription, got %v", err)
+	}
+	if infos := conn.Subscriptions(); len(infos) != 1 || infos[0].Subject != "sync.other" {
+		t.Fatalf("unexpected subscriptions %+v", infos)
+	}
+
+	// the channels of the remaining subscriptions are closed when the Connection stops
+	if err = conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range other.Messages() {
+		t.Fatal("unexpected message after Close")
+	}
+}
+
+func TestLatencyNotifies(t *testing.T) {
+	if status := (&Connection{}).Status(); status != StatusConnecting {
+		t.Fatalf("expected %s before the first state, got %s", StatusConnecting, status)
+	}
+
+	latencies := make(chan time.Duration, 16)
+	tc := newTestConnection(t, func(_ *Proxy, dialer *Dialer) {
+		dialer.Options.HeartbeatInterval = 50 * time.Millisecond
+		dialer.OnChange = func(conn *Connection) {
+			if latency := conn.Latency(); latency > 0 {
+				select {
+				case latencies <- latency:
+				default:
+				}
+			}
+		}
+	})
+	conn, ctx := tc.Connection, tc.ctx
+
+	select {
+	case <-latencies:
+	case <-ctx.Done():
+		t.Fatal("latency not notified")
+	}
+	if reason := conn.ChangeReason(); reason != Connect {
+		t.Fatalf("expected the latency to keep ChangeReason %s, got %s", Connect, reason)
+	}
+}
+
+func TestPublishQueuesBehindOutbox(t *testing.T) {
+	tc := newTestConnection(t, func(_ *Proxy, dialer *Dialer) { dialer.Options.OutboxSize = 10 })
+	conn, ctx := tc.Connection, tc.ctx
+
+	sub, err := conn.SubscribeSync("outbox.order")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a message left from before the connect, as when the outbox flush has not run yet
+	queued := nats.NewMsg("outbox.order")
+	queued.Data = []byte("queued")
+	if err = conn.outbox.add(conn, OutboxMsg{ID: "queued", Subject: queued.Subject, Header: queued.Header,
+		Data: queued.Data}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.Publish("outbox.order", []byte("published")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"queued", "published"} {
+		msg, nextErr := sub.NextMsg(ctx)
+		if nextErr != nil {
+			t.Fatal(nextErr)
+		}
+		if string(msg.Data) != expected {
+			t.Fatalf("expected %q, got %q", expected, msg.Data)
+		}
+	}
+}
+
+func TestStatisticsReconnects(t *testing.T) {
+	tc := newTestConnection(t)
+	conn := tc.Connection
+
+	previous := conn.natsConnection()
+	conn.ReconnectNow()
+	waitFor(t, tc.ctx, "reconnect", func() bool {
+		current := conn.natsConnection()
+		return current != nil && current != previous && current.IsConnected()
+	})
+	if reconnects := conn.Statistics().Reconnects; reconnects != 1 {
+		t.Fatalf("expected 1 reconnect, got %d", reconnects)
+	}
+
+	// closing is not a reconnect
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if reconnects := conn.Statistics().Reconnects; reconnects != 1 {
+		t.Fatalf("expected 1 reconnect after Close, got %d", reconnects)
+	}
+}
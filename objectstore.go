@@ -0,0 +1,270 @@
+package natsws
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/maxence-charriere/go-app/v9/pkg/app"
+	"github.com/nats-io/nats.go"
+	"io"
+)
+
+// DefaultChunkSize is the default ObjectOptions.ChunkSize, the same as the nats.go default.
+// Connection splits the chunks into websocket messages that pass a Proxy with the DefaultReadLimit.
+const DefaultChunkSize = 128 * 1024
+
+// natsWriteBuffer is the size at which a nats client flushes buffered protocol operations.
+const natsWriteBuffer = 32 * 1024
+
+// MinChunkSize is the smallest chunk size MaxChunkSize returns.
+const MinChunkSize = 1024
+
+// MaxChunkSize returns the largest Object Store chunk size that a nats client other than
+// Connection passes through a Proxy with readLimit, it leaves room for a full nats client
+// write buffer and the protocol line of the chunk. Chunks are also limited by the max_payload
+// of the nats server, 1MiB by default.
+//
+// A readLimit of zero is the DefaultReadLimit of the Proxy. Limits that do not fit a full write
+// buffer return MinChunkSize, which only passes while little else is published, raise the
+// Proxy.ReadLimit to ObjectReadLimit for uploads.
+func MaxChunkSize(readLimit int64) int {
+	if readLimit <= 0 {
+		readLimit = DefaultReadLimit
+	}
+	if size := int(readLimit) - natsWriteBuffer - 1024; size > MinChunkSize {
+		return size
+	}
+	return MinChunkSize
+}
+
+// ObjectOptions configure an Object Store upload or download.
+type ObjectOptions struct {
+	// ChunkSize is the size of the chunks an upload is split into, DefaultChunkSize when not positive.
+	// Each chunk is a single nats message, see MaxChunkSize for the sizes a Proxy passes.
+	ChunkSize int
+	// Description is stored with an uploaded object.
+	Description string
+	// OnProgress is called on the UI goroutine with the bytes transferred so far and the total size.
+	OnProgress func(ctx app.Context, transferred, total int64)
+}
+
+// ObjectStore returns the JetStream Object Store bucket.
+func (c *Connection) ObjectStore(bucket string) (store nats.ObjectStore, err error) {
+	var js nats.JetStreamContext
+	if js, err = c.JetStream(); err != nil {
+		return
+	}
+	return js.ObjectStore(bucket)
+}
+
+// PutObject uploads size bytes of reader as the object name of bucket. Progress is reported
+// to options.OnProgress and the upload is cancelled and its chunks purged when ctx is done.
+// The chunk size and description are set on a copy of meta, a nil meta is an empty ObjectMeta.
+//
+// PutObject blocks until the upload completes, call it from ctx.Async.
+func (c *Connection) PutObject(ctx app.Context, bucket string, meta *nats.ObjectMeta, reader io.Reader, size int64,
+	options ObjectOptions) (info *nats.ObjectInfo, err error) {
+
+	var store nats.ObjectStore
+	if store, err = c.ObjectStore(bucket); err != nil {
+		return
+	}
+
+	chunkSize := options.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	if meta == nil {
+		meta = &nats.ObjectMeta{}
+	}
+	put, opts := *meta, nats.ObjectMetaOptions{}
+	if meta.Opts != nil {
+		opts = *meta.Opts
+	}
+	opts.ChunkSize = uint32(chunkSize)
+	put.Opts = &opts
+	if put.Description == "" {
+		put.Description = options.Description
+	}
+
+	reader = &progressReader{ctx: ctx, reader: reader, report: options.progress(ctx, size)}
+	return store.Put(&put, reader, nats.Context(ctx))
+}
+
+// PutBlob uploads a browser File or Blob in chunks as the object name of bucket, the name
+// of a File is used when name is empty. The type of the Blob is stored as the ContentTypeHeader.
+//
+// PutBlob blocks until the upload completes, call it from ctx.Async.
+func (c *Connection) PutBlob(ctx app.Context, bucket, name string, blob app.Value,
+	options ObjectOptions) (info *nats.ObjectInfo, err error) {
+
+	if name == "" {
+		name = blob.Get("name").String()
+	}
+	meta := &nats.ObjectMeta{Name: name}
+	if contentType := blob.Get("type").String(); contentType != "" {
+		meta.Headers = nats.Header{}
+		meta.Headers.Set(ContentTypeHeader, contentType)
+	}
+
+	size := int64(blob.Get("size").Int())
+	return c.PutObject(ctx, bucket, meta, &blobReader{ctx: ctx, blob: blob, size: size}, size, options)
+}
+
+// GetObject downloads the object name of bucket. Progress is reported to options.OnProgress
+// and the download is cancelled when ctx is done.
+//
+// GetObject blocks until the download completes, call it from ctx.Async.
+func (c *Connection) GetObject(ctx app.Context, bucket, name string,
+	options ObjectOptions) (data []byte, info *nats.ObjectInfo, err error) {
+
+	var store nats.ObjectStore
+	if store, err = c.ObjectStore(bucket); err != nil {
+		return
+	}
+
+	var result nats.ObjectResult
+	if result, err = store.Get(name, nats.Context(ctx)); err != nil {
+		return
+	}
+	defer func() { _ = result.Close() }()
+
+	if info, err = result.Info(); err != nil {
+		return
+	}
+
+	buffer := bytes.NewBuffer(make([]byte, 0, info.Size))
+	reader := &progressReader{ctx: ctx, reader: result, report: options.progress(ctx, int64(info.Size))}
+	if _, err = io.Copy(buffer, reader); err != nil {
+		return
+	}
+	return buffer.Bytes(), info, nil
+}
+
+// GetBlobURL downloads the object name of bucket into a browser Blob and returns an object url
+// for it, for example to use as the href of a download link. The ContentTypeHeader of the
+// object is used as the type of the Blob. Release the url with RevokeBlobURL when done.
+//
+// GetBlobURL blocks until the download completes, call it from ctx.Async.
+func (c *Connection) GetBlobURL(ctx app.Context, bucket, name string,
+	options ObjectOptions) (url string, info *nats.ObjectInfo, err error) {
+
+	var data []byte
+	if data, info, err = c.GetObject(ctx, bucket, name, options); err != nil {
+		return
+	}
+
+	array := app.Window().Get("Uint8Array").New(len(data))
+	app.CopyBytesToJS(array, data)
+
+	blobOptions := map[string]any{}
+	if contentType := info.Headers.Get(ContentTypeHeader); contentType != "" {
+		blobOptions["type"] = contentType
+	}
+	blob := app.Window().Get("Blob").New([]any{array}, blobOptions)
+	return app.Window().Get("URL").Call("createObjectURL", blob).String(), info, nil
+}
+
+// RevokeBlobURL releases an object url returned by GetBlobURL.
+func RevokeBlobURL(url string) {
+	app.Window().Get("URL").Call("revokeObjectURL", url)
+}
+
+func (o ObjectOptions) progress(ctx app.Context, total int64) func(transferred int64) {
+	if o.OnProgress == nil {
+		return nil
+	}
+	return func(transferred int64) {
+		ctx.Dispatch(func(ctx app.Context) { o.OnProgress(ctx, transferred, total) })
+	}
+}
+
+// progressReader reports the bytes read and stops reading when ctx is done.
+type progressReader struct {
+	ctx         context.Context
+	reader      io.Reader
+	report      func(transferred int64)
+	transferred int64
+}
+
+func (r *progressReader) Read(p []byte) (n int, err error) {
+	if err = r.ctx.Err(); err != nil {
+		return
+	}
+	n, err = r.reader.Read(p)
+	if n > 0 {
+		r.transferred += int64(n)
+		if r.report != nil {
+			r.report(r.transferred)
+		}
+	}
+	return
+}
+
+var errBlobRead = errors.New("natsws: blob read failed")
+
+// blobReader reads a browser Blob one slice per Read.
+type blobReader struct {
+	ctx    context.Context
+	blob   app.Value
+	size   int64
+	offset int64
+}
+
+func (r *blobReader) Read(p []byte) (n int, err error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	end := r.offset + int64(len(p))
+	if end > r.size {
+		end = r.size
+	}
+
+	buffers := make(chan app.Value, 1)
+	errs := make(chan error, 1)
+	settle(r.blob.Call("slice", r.offset, end).Call("arrayBuffer"), func(buffer app.Value) {
+		buffers <- buffer
+	}, func(reason app.Value) {
+		errs <- fmt.Errorf("%w: %s", errBlobRead, reason.Call("toString").String())
+	})
+
+	select {
+	case <-r.ctx.Done():
+		return 0, r.ctx.Err()
+	case err = <-errs:
+		return 0, err
+	case buffer := <-buffers:
+		if n = app.CopyBytesToGo(p, app.Window().Get("Uint8Array").New(buffer)); n == 0 {
+			return 0, errBlobRead
+		}
+	}
+	r.offset += int64(n)
+	return
+}
+
+// settle calls resolve or reject once promise settles, app.Value.Then ignores rejected promises.
+func settle(promise app.Value, resolve, reject func(app.Value)) {
+	var onResolve, onReject app.Func
+	release := func() {
+		onResolve.Release()
+		onReject.Release()
+	}
+	arg := func(args []app.Value) (value app.Value) {
+		if len(args) > 0 {
+			value = args[0]
+		}
+		return
+	}
+	onResolve = app.FuncOf(func(this app.Value, args []app.Value) any {
+		resolve(arg(args))
+		release()
+		return nil
+	})
+	onReject = app.FuncOf(func(this app.Value, args []app.Value) any {
+		reject(arg(args))
+		release()
+		return nil
+	})
+	promise.Call("then", onResolve, onReject)
+}
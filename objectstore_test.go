@@ -0,0 +1,161 @@
+//go:build !wasm
+
+package natsws
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/maxence-charriere/go-app/v9/pkg/app"
+	"github.com/nats-io/nats.go"
+	"testing"
+)
+
+func TestObjectStoreChunkSizes(t *testing.T) {
+	tests := []struct {
+		readLimit int64
+		chunkSize int
+	}{
+		{0, 1024},
+		{ObjectReadLimit, 16 * 1024},
+		{ObjectReadLimit, DefaultChunkSize},
+		{ObjectReadLimit, 1024 * 1024},
+		{64 * 1024, MaxChunkSize(64 * 1024)},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("limit %d chunk %d", test.readLimit, test.chunkSize), func(t *testing.T) {
+			_, wsUrl := newTestProxy(t, func(proxy *Proxy) { proxy.ReadLimit = test.readLimit })
+			dialer := &browserDialer{url: wsUrl + "test"}
+			conn, err := nats.Connect("127.0.0.1:4222", nats.SetCustomDialer(dialer), nats.MaxReconnects(0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			js, err := conn.JetStream()
+			if err != nil {
+				t.Fatal(err)
+			}
+			bucket := fmt.Sprintf("chunks-%d", test.chunkSize)
+			store, err := js.CreateObjectStore(&nats.ObjectStoreConfig{Bucket: bucket})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = js.DeleteObjectStore(bucket) }()
+
+			data := bytes.Repeat([]byte("0123456789abcdef"), (3*test.chunkSize+17)/16+1)
+			meta := &nats.ObjectMeta{Name: "file", Opts: &nats.ObjectMetaOptions{ChunkSize: uint32(test.chunkSize)}}
+			if _, err = store.Put(meta, bytes.NewReader(data)); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := store.GetBytes("file")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("downloaded %d bytes, uploaded %d", len(got), len(data))
+			}
+			if !conn.IsConnected() {
+				t.Fatal("proxy closed the connection")
+			}
+		})
+	}
+}
+
+func TestMaxChunkSize(t *testing.T) {
+	for readLimit, expected := range map[int64]int{
+		0:                MinChunkSize,
+		DefaultReadLimit: MinChunkSize,
+		1:                MinChunkSize,
+		64 * 1024:        31 * 1024,
+		ObjectReadLimit:  1024*1024 + 31*1024,
+	} {
+		if size := MaxChunkSize(readLimit); size != expected {
+			t.Fatalf("expected chunk size %d for read limit %d, got %d", expected, readLimit, size)
+		}
+	}
+}
+
+func TestPutObjectKeepsMeta(t *testing.T) {
+	tc := newTestConnection(t, func(proxy *Proxy, _ *Dialer) { proxy.ReadLimit = ObjectReadLimit })
+	conn, ctx := tc.Connection, tc.ctx
+	_, js := tc.backend(t)
+	if _, err := js.CreateObjectStore(&nats.ObjectStoreConfig{Bucket: "files"}); err != nil {
+		t.Fatal(err)
+	}
+
+	compo := &testCompo{}
+	dispatcher := app.NewClientTester(compo)
+	defer dispatcher.Close()
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1024)
+	meta := &nats.ObjectMeta{Name: "file"}
+	info, err := conn.PutObject(asyncContext{Context: compo.ctx, ctx: ctx}, "files", meta, bytes.NewReader(data),
+		int64(len(data)), ObjectOptions{ChunkSize: 4096, Description: "sixteen"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Chunks != 4 || info.Description != "sixteen" {
+		t.Fatalf("unexpected object %+v", info)
+	}
+	if meta.Opts != nil || meta.Description != "" {
+		t.Fatalf("expected meta to be unchanged, got %+v", meta)
+	}
+}
+
+func TestObjectStoreDefaultProxy(t *testing.T) {
+	tc := newTestConnection(t)
+	conn, ctx := tc.Connection, tc.ctx
+	_, js := tc.backend(t)
+	if _, err := js.CreateObjectStore(&nats.ObjectStoreConfig{Bucket: "files"}); err != nil {
+		t.Fatal(err)
+	}
+
+	compo := &testCompo{}
+	dispatcher := app.NewClientTester(compo)
+	defer dispatcher.Close()
+	appCtx := asyncContext{Context: compo.ctx, ctx: ctx}
+
+	// a nil meta is an empty ObjectMeta, which has no name
+	if _, err := conn.PutObject(appCtx, "files", nil, bytes.NewReader(nil), 0, ObjectOptions{}); !errors.Is(err, nats.ErrBadObjectMeta) {
+		t.Fatalf("expected nats.ErrBadObjectMeta, got %v", err)
+	}
+
+	// DefaultChunkSize chunks pass the DefaultReadLimit of the Proxy in both directions
+	data := bytes.Repeat([]byte("0123456789abcdef"), 3*DefaultChunkSize/16+1)
+	info, err := conn.PutObject(appCtx, "files", &nats.ObjectMeta{Name: "file"}, bytes.NewReader(data),
+		int64(len(data)), ObjectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Chunks != 4 {
+		t.Fatalf("expected 4 chunks, got %+v", info)
+	}
+	got, _, err := conn.GetObject(appCtx, "files", "file", ObjectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("downloaded %d bytes, uploaded %d", len(got), len(data))
+	}
+	if !conn.IsConnected() {
+		t.Fatal("proxy closed the connection")
+	}
+}
+
+func TestProgressReaderCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var reported int64
+	reader := &progressReader{ctx: ctx, reader: bytes.NewReader(make([]byte, 10)),
+		report: func(transferred int64) { reported = transferred }}
+
+	if n, err := reader.Read(make([]byte, 4)); n != 4 || err != nil || reported != 4 {
+		t.Fatalf("unexpected read %d %v, reported %d", n, err, reported)
+	}
+	cancel()
+	if _, err := reader.Read(make([]byte, 4)); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package natsws
+
+import (
+	"context"
+	"github.com/maxence-charriere/go-app/v9/pkg/app"
+	"github.com/nats-io/nats.go"
+	"math/rand"
+	"time"
+)
+
+// Options configure how a Connection connects and reconnects.
+//
+// Zero values use the nats.go defaults. Component and Dialer take Options together
+// with a Tracer, which when set traces publish, request and subscription callbacks,
+// and NatsOptions, which are applied after the nats options set by the Connection.
+type Options struct {
+	// ReconnectWait is the delay before the first reconnect attempt.
+	ReconnectWait time.Duration
+	// MaxReconnectWait enables exponential backoff, the delay doubles with
+	// each failed attempt up to MaxReconnectWait.
+	MaxReconnectWait time.Duration
+	// ReconnectJitter adds a random delay of up to ReconnectJitter to each attempt
+	// so that many clients do not reconnect in lockstep after a server restart.
+	ReconnectJitter time.Duration
+	// MaxReconnects is the number of attempts before the connection is closed, -1 retries forever.
+	MaxReconnects int
+	// ConnectTimeout bounds the websocket dial and the nats handshake.
+	ConnectTimeout time.Duration
+	// PauseWhenOffline pauses reconnect attempts while navigator.onLine is false.
+	PauseWhenOffline bool
+	// PauseWhenHidden pauses reconnect attempts while the browser tab is hidden.
+	PauseWhenHidden bool
+	// SuspendWhenHidden closes the connection after the browser tab has been hidden
+	// for this duration, it reconnects when the tab is visible again.
+	SuspendWhenHidden time.Duration
+	// HeartbeatInterval is the interval of the round trip measurement, the default is 5 seconds.
+	HeartbeatInterval time.Duration
+	// HeartbeatSubject, when set, receives a "ping from <clientName>" message on each heartbeat.
+	HeartbeatSubject string
+	// DisableHeartbeat disables heartbeats and latency measurement.
+	DisableHeartbeat bool
+	// OutboxSize enables queueing of up to OutboxSize messages published
+	// while the Connection is not connected, see Connection.PublishOutbox.
+	OutboxSize int
+	// OutboxTTL is the default time to live of queued messages, zero never expires.
+	OutboxTTL time.Duration
+	// PersistOutbox keeps queued messages in local storage across page reloads.
+	PersistOutbox bool
+	// ShareAcrossTabs shares one nats connection between the tabs of a browser that use
+	// the same client name. One leader tab connects and the other tabs relay through it,
+	// when the leader tab closes another tab takes over. Requires the Web Locks API.
+	ShareAcrossTabs bool
+	// DisableDrainOnUnload disables closing the nats connection when the page is hidden for a
+	// navigation, which writes the pending publishes and closes the websocket with a normal closure.
+	DisableDrainOnUnload bool
+	// StatsInterval enables periodic Stats snapshots in the go-app state, see ObserveStats.
+	StatsInterval time.Duration
+}
+
+const pausePollInterval = time.Second
+
+func (o Options) natsOptions(c *Connection, current func() bool) (opts []nats.Option) {
+	opts = append(opts, nats.CustomReconnectDelay(func(attempts int) time.Duration {
+		return c.reconnectDelay(attempts, current)
+	}))
+	if o.MaxReconnects != 0 {
+		opts = append(opts, nats.MaxReconnects(o.MaxReconnects))
+	}
+	if o.ConnectTimeout > 0 {
+		opts = append(opts, nats.Timeout(o.ConnectTimeout))
+	}
+	return
+}
+
+// backoff returns the delay before reconnect attempt number attempts, starting at one.
+func (o Options) backoff(attempts int) time.Duration {
+	wait := o.ReconnectWait
+	if wait <= 0 {
+		wait = nats.DefaultReconnectWait
+	}
+	for i := 1; i < attempts && wait < o.MaxReconnectWait; i++ {
+		wait *= 2
+	}
+	if o.MaxReconnectWait > 0 && wait > o.MaxReconnectWait {
+		wait = o.MaxReconnectWait
+	}
+	return wait + o.jitter()
+}
+
+func (o Options) jitter() time.Duration {
+	jitter := o.ReconnectJitter
+	if jitter <= 0 {
+		jitter = nats.DefaultReconnectJitter
+	}
+	return time.Duration(rand.Int63n(int64(jitter)))
+}
+
+// reconnectDelay is the nats.ReconnectDelayHandler of the Connection, it blocks
+// while reconnects are paused so paused time does not count as reconnect attempts.
+func (c *Connection) reconnectDelay(attempts int, current func() bool) time.Duration {
+	paused := false
+	for c.reconnectPaused() {
+		if !current() || c.ctx().Err() != nil {
+			break
+		}
+		paused = true
+		time.Sleep(pausePollInterval)
+	}
+	if paused {
+		return c.options.jitter()
+	}
+	return c.options.backoff(attempts)
+}
+
+func (c *Connection) reconnectPaused() bool {
+	if !app.IsClient {
+		return false
+	}
+	if c.options.PauseWhenOffline && !app.Window().Get("navigator").Get("onLine").Bool() {
+		return true
+	}
+	return c.options.PauseWhenHidden && app.Window().Get("document").Get("hidden").Bool()
+}
+
+// dialContext bounds a websocket dial by Options.ConnectTimeout.
+func (c *Connection) dialContext() (context.Context, context.CancelFunc) {
+	if c.options.ConnectTimeout > 0 {
+		return context.WithTimeout(c.ctx(), c.options.ConnectTimeout)
+	}
+	return context.WithCancel(c.ctx())
+}
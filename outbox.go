@@ -0,0 +1,171 @@
+package natsws
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"sync"
+	"time"
+)
+
+var ErrOutboxFull = errors.New("natsws: outbox full")
+var ErrOutboxExpired = errors.New("natsws: outbox message expired")
+
+// OutboxMsg is a message queued while the Connection is not connected.
+type OutboxMsg struct {
+	// ID deduplicates queued messages and is sent as the nats.MsgIdHdr header.
+	ID      string
+	Subject string
+	Header  nats.Header
+	Data    []byte
+	Queued  time.Time
+	// Expires is zero when the message does not expire.
+	Expires time.Time
+}
+
+// DeliveryHandler is called once a queued message is published, or with
+// ErrOutboxExpired when it expired first. Handlers do not survive a page reload.
+type DeliveryHandler func(msg OutboxMsg, err error)
+
+// outbox is shared by all copies of a Connection.
+type outbox struct {
+	mu       sync.Mutex
+	msgs     []OutboxMsg
+	handlers map[string]DeliveryHandler
+}
+
+func (o *outbox) load(c *Connection) {
+	if c.options.PersistOutbox {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		c.binding.getState(StateKey(c.name)+".outbox", &o.msgs)
+	}
+}
+
+func (o *outbox) save(c *Connection) {
+	if c.options.PersistOutbox {
+		c.binding.setState(StateKey(c.name)+".outbox", o.msgs, true)
+	}
+}
+
+func (o *outbox) add(c *Connection, msg OutboxMsg, handler DeliveryHandler) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, queued := range o.msgs {
+		if queued.ID == msg.ID {
+			return nil
+		}
+	}
+	if len(o.msgs) >= c.options.OutboxSize {
+		return ErrOutboxFull
+	}
+	o.msgs = append(o.msgs, msg)
+	if handler != nil {
+		o.handlers[msg.ID] = handler
+	}
+	o.save(c)
+	return nil
+}
+
+// flush publishes queued messages in order, stopping at the first publish error.
+func (o *outbox) flush(c *Connection, publish func(msg *nats.Msg) error) {
+	o.mu.Lock()
+	type delivery struct {
+		msg     OutboxMsg
+		err     error
+		handler DeliveryHandler
+	}
+	var deliveries []delivery
+	now := time.Now()
+	for len(o.msgs) > 0 {
+		msg := o.msgs[0]
+		var err error
+		if msg.Expires.IsZero() || now.Before(msg.Expires) {
+			natsMsg := &nats.Msg{Subject: msg.Subject, Header: msg.Header, Data: msg.Data}
+			if err = publish(natsMsg); err != nil {
+				break
+			}
+		} else {
+			err = ErrOutboxExpired
+		}
+		o.msgs = o.msgs[1:]
+		deliveries = append(deliveries, delivery{msg: msg, err: err, handler: o.handlers[msg.ID]})
+		delete(o.handlers, msg.ID)
+	}
+	o.save(c)
+	o.mu.Unlock()
+
+	for _, d := range deliveries {
+		if d.handler != nil {
+			d.handler(d.msg, d.err)
+		}
+	}
+}
+
+// queued reports whether messages are waiting, new messages queue behind them to keep their order.
+func (o *outbox) queued() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.msgs) > 0
+}
+
+func (o *outbox) pending() []OutboxMsg {
+	if o == nil {
+		return nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]OutboxMsg{}, o.msgs...)
+}
+
+// PublishOutbox publishes msg, or queues it in the outbox when the Connection is not
+// connected or older messages are still queued. Queued messages are published in
+// order after the next Connect or Reconnect.
+//
+// A ttl of zero uses Options.OutboxTTL, onDelivery may be nil.
+// The returned id is the nats.MsgIdHdr header of msg, which is generated when missing.
+func (c *Connection) PublishOutbox(msg *nats.Msg, ttl time.Duration, onDelivery DeliveryHandler) (id string, err error) {
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+	if id = msg.Header.Get(nats.MsgIdHdr); id == "" {
+		id = uuid.NewString()
+		msg.Header.Set(nats.MsgIdHdr, id)
+	}
+
+	if c.outbox == nil || c.options.OutboxSize <= 0 || (c.IsConnected() && !c.outbox.queued()) {
+		if err = c.PublishMsg(msg); err == nil && onDelivery != nil {
+			onDelivery(OutboxMsg{ID: id, Subject: msg.Subject, Header: msg.Header, Data: msg.Data}, nil)
+		}
+		return
+	}
+
+	if ttl <= 0 {
+		ttl = c.options.OutboxTTL
+	}
+	queued := OutboxMsg{ID: id, Subject: msg.Subject, Header: msg.Header, Data: msg.Data, Queued: time.Now()}
+	if ttl > 0 {
+		queued.Expires = queued.Queued.Add(ttl)
+	}
+	if err = c.outbox.add(c, queued, onDelivery); err == nil && c.IsConnected() {
+		// connected with older messages still queued, flush them and msg in order
+		c.do(c.flushOutbox)
+	}
+	return
+}
+
+// Outbox returns the messages waiting to be published.
+func (c *Connection) Outbox() []OutboxMsg {
+	return c.outbox.pending()
+}
+
+func (c *Connection) flushOutbox() {
+	if c.outbox == nil || !c.IsConnected() {
+		return
+	}
+	if c.relay.isFollower() {
+		c.outbox.flush(c, c.relay.publish)
+	} else if conn := c.natsConnection(); conn != nil {
+		c.outbox.flush(c, conn.PublishMsg)
+	}
+}
@@ -0,0 +1,70 @@
+package natsws
+
+import (
+	"errors"
+	"github.com/nats-io/nats.go"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOutbox(t *testing.T) {
+	c := &Connection{options: Options{OutboxSize: 3}, outbox: &outbox{handlers: map[string]DeliveryHandler{}}}
+
+	var delivered []string
+	var expired []string
+	onDelivery := func(msg OutboxMsg, err error) {
+		if errors.Is(err, ErrOutboxExpired) {
+			expired = append(expired, msg.ID)
+		} else if err == nil {
+			delivered = append(delivered, msg.ID)
+		}
+	}
+	queue := func(id string, ttl time.Duration) error {
+		msg := nats.NewMsg("outbox")
+		msg.Header.Set(nats.MsgIdHdr, id)
+		_, err := c.PublishOutbox(msg, ttl, onDelivery)
+		return err
+	}
+
+	for _, id := range []string{"a", "b", "a"} {
+		if err := queue(id, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := queue("c", time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	// "a" was deduplicated, the outbox holds a, b and c
+	if err := queue("d", 0); !errors.Is(err, ErrOutboxFull) {
+		t.Fatalf("expected ErrOutboxFull, got %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	// the first publish error stops the flush, the message stays queued
+	fail := true
+	var published []string
+	publish := func(msg *nats.Msg) error {
+		if fail {
+			fail = false
+			return nats.ErrConnectionClosed
+		}
+		published = append(published, msg.Header.Get(nats.MsgIdHdr))
+		return nil
+	}
+	c.outbox.flush(c, publish)
+	if len(c.Outbox()) != 3 || len(delivered) != 0 {
+		t.Fatalf("expected 3 queued messages, got %+v", c.Outbox())
+	}
+
+	c.outbox.flush(c, publish)
+	if len(c.Outbox()) != 0 {
+		t.Fatalf("expected an empty outbox, got %+v", c.Outbox())
+	}
+	if !reflect.DeepEqual(published, []string{"a", "b"}) || !reflect.DeepEqual(delivered, []string{"a", "b"}) {
+		t.Fatalf("unexpected order, published %v delivered %v", published, delivered)
+	}
+	if !reflect.DeepEqual(expired, []string{"c"}) {
+		t.Fatalf("expected c to expire, got %v", expired)
+	}
+}
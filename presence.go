@@ -0,0 +1,197 @@
+package natsws
+
+import (
+	"encoding/json"
+	"github.com/maxence-charriere/go-app/v9/pkg/app"
+	"github.com/nats-io/nats.go"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// PresenceSubject is the subject prefix of the presence subsystem. Clients send PresenceEvents
+// on PresenceSubject.<room> and the PresenceServer publishes the PresenceRoster of a room on
+// PresenceSubject.<room>.roster when it changes.
+const PresenceSubject = "natsws.presence"
+
+// Types of PresenceEvent.
+const (
+	PresenceJoin      = "join"
+	PresenceLeave     = "leave"
+	PresenceHeartbeat = "heartbeat"
+)
+
+// DefaultPresenceInterval is the interval of presence heartbeats.
+const DefaultPresenceInterval = 10 * time.Second
+
+const presenceRetry = time.Second
+
+// PresenceEvent is sent by a client to announce a tab in a room.
+type PresenceEvent struct {
+	Type       string `json:"type"`
+	ClientName string `json:"clientName"`
+	TabId      string `json:"tabId"`
+}
+
+// PresenceMember is a client that is online in a room.
+type PresenceMember struct {
+	ClientName string `json:"clientName"`
+	// Tabs is the number of tabs of the client in the room.
+	Tabs int `json:"tabs"`
+	// Since is when the first tab of the client joined.
+	Since time.Time `json:"since"`
+}
+
+// PresenceRoster lists the members of a room ordered by client name.
+type PresenceRoster struct {
+	Room    string           `json:"room"`
+	Members []PresenceMember `json:"members"`
+}
+
+func presenceSubject(room string) string {
+	return PresenceSubject + "." + room
+}
+
+func presenceRosterSubject(room string) string {
+	return presenceSubject(room) + ".roster"
+}
+
+// Presence announces the Connection in a room and tracks the roster kept by the PresenceServer.
+type Presence struct {
+	// OnChange is called on the UI goroutine when the roster of the room changes.
+	OnChange func(ctx app.Context, roster PresenceRoster)
+
+	ctx  app.Context
+	conn *Connection
+	room string
+
+	mu     sync.Mutex
+	roster PresenceRoster
+	sub    *subscription
+	left   bool
+	done   chan struct{}
+}
+
+// JoinPresence joins room until ctx is done or Leave is called. Pass the observed Connection
+// of the component, it must not be nil. Room names must be a single subject token.
+//
+// A heartbeat is sent every DefaultPresenceInterval, it joins the room again after the
+// Connection was offline long enough for the PresenceServer to expire the tab.
+func JoinPresence(ctx app.Context, conn *Connection, room string) *Presence {
+	p := &Presence{ctx: ctx, conn: conn, room: room, roster: PresenceRoster{Room: room}, done: make(chan struct{})}
+	ctx.Async(p.run)
+	return p
+}
+
+func (p *Presence) run() {
+	subscribed := false
+	joined := false
+	for {
+		if !subscribed {
+			subscribed = p.subscribe()
+		}
+
+		wait := presenceRetry
+		if subscribed {
+			eventType := PresenceHeartbeat
+			if !joined {
+				eventType = PresenceJoin
+			}
+			if err := p.announce(eventType); err == nil {
+				joined = true
+				wait = DefaultPresenceInterval
+			}
+		}
+
+		select {
+		case <-p.ctx.Done():
+			p.Leave()
+			return
+		case <-p.done:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// subscribe subscribes to the roster of the room until Leave.
+func (p *Presence) subscribe() bool {
+	sub, err := p.conn.subscribe(presenceRosterSubject(p.room), "", p.onRoster)
+	if err != nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.left {
+		_ = p.conn.removeSubscription(sub)
+		return false
+	}
+	p.sub = sub
+	return true
+}
+
+// announce sends event and applies the roster the PresenceServer responds with.
+func (p *Presence) announce(eventType string) (err error) {
+	var data []byte
+	event := PresenceEvent{Type: eventType, ClientName: p.conn.ClientName(), TabId: p.conn.TabID()}
+	if data, err = json.Marshal(event); err != nil {
+		return
+	}
+	var response *nats.Msg
+	if response, err = p.conn.Request(presenceSubject(p.room), data, presenceRetry); err != nil {
+		return
+	}
+	p.onRoster(response)
+	return
+}
+
+func (p *Presence) onRoster(msg *nats.Msg) {
+	var roster PresenceRoster
+	if err := json.Unmarshal(msg.Data, &roster); err != nil {
+		return
+	}
+
+	p.mu.Lock()
+	changed := !p.left && !reflect.DeepEqual(roster, p.roster)
+	if changed {
+		p.roster = roster
+	}
+	p.mu.Unlock()
+
+	if changed && p.OnChange != nil {
+		p.ctx.Dispatch(func(ctx app.Context) {
+			p.OnChange(ctx, roster)
+		})
+	}
+}
+
+// Members returns the members of the room.
+func (p *Presence) Members() []PresenceMember {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]PresenceMember{}, p.roster.Members...)
+}
+
+// Leave announces that the tab left the room, stops the heartbeats and unsubscribes from the roster.
+// It is called when the ctx of JoinPresence is done.
+func (p *Presence) Leave() {
+	p.mu.Lock()
+	if p.left {
+		p.mu.Unlock()
+		return
+	}
+	p.left = true
+	close(p.done)
+	sub := p.sub
+	p.sub = nil
+	p.mu.Unlock()
+
+	if sub != nil {
+		_ = p.conn.removeSubscription(sub)
+	}
+
+	event := PresenceEvent{Type: PresenceLeave, ClientName: p.conn.ClientName(), TabId: p.conn.TabID()}
+	if data, err := json.Marshal(event); err == nil {
+		_ = p.conn.Publish(presenceSubject(p.room), data)
+	}
+}
@@ -0,0 +1,233 @@
+package natsws
+
+import (
+	"encoding/json"
+	"github.com/nats-io/nats.go"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SessionListener is notified by the Proxy when websocket sessions start and end.
+type SessionListener interface {
+	SessionStarted(clientId, tabId string)
+	SessionEnded(clientId, tabId string)
+}
+
+var _ SessionListener = (*PresenceServer)(nil)
+
+// PresenceServer keeps the authoritative roster of each presence room on the backend.
+//
+// Tabs are removed when they leave, when no heartbeat arrived within the timeout, or
+// immediately when their Proxy session ends if the PresenceServer is set as Proxy.Sessions.
+//
+// PresenceEvents carry the client name and tab id of the sender. Set as Proxy.Sessions, the
+// PresenceServer only accepts events of tabs with a live Proxy session, whose client name
+// the Proxy checked with its ClientNamer, so that clients cannot join or leave as another client.
+// Events of clients that do not connect through the Proxy are ignored then.
+type PresenceServer struct {
+	conn    *nats.Conn
+	timeout time.Duration
+	sub     *nats.Subscription
+	stop    chan struct{}
+	stopped sync.Once
+
+	mu    sync.Mutex
+	rooms map[string]map[presenceTab]*presenceEntry
+	// sessions counts the live Proxy sessions of each tab, it is nil until the first session starts
+	sessions map[presenceTab]int
+}
+
+type presenceTab struct {
+	clientName string
+	tabId      string
+}
+
+type presenceEntry struct {
+	joined   time.Time
+	lastSeen time.Time
+}
+
+// StartPresenceServer serves the presence rooms on conn. Tabs expire when no heartbeat arrived
+// within timeout, three times DefaultPresenceInterval when zero.
+func StartPresenceServer(conn *nats.Conn, timeout time.Duration) (s *PresenceServer, err error) {
+	if timeout <= 0 {
+		timeout = 3 * DefaultPresenceInterval
+	}
+	s = &PresenceServer{conn: conn, timeout: timeout, stop: make(chan struct{}),
+		rooms: map[string]map[presenceTab]*presenceEntry{}}
+	if s.sub, err = conn.Subscribe(presenceSubject("*"), s.onEvent); err != nil {
+		return nil, err
+	}
+	go s.expire()
+	return
+}
+
+// Stop stops serving the presence rooms, it can be called more than once.
+func (s *PresenceServer) Stop() {
+	s.stopped.Do(func() {
+		close(s.stop)
+		_ = s.sub.Unsubscribe()
+	})
+}
+
+func (s *PresenceServer) onEvent(msg *nats.Msg) {
+	var event PresenceEvent
+	if err := json.Unmarshal(msg.Data, &event); err != nil || event.ClientName == "" {
+		return
+	}
+	room := strings.TrimPrefix(msg.Subject, PresenceSubject+".")
+	tab := presenceTab{clientName: event.ClientName, tabId: event.TabId}
+
+	s.mu.Lock()
+	if s.sessions != nil && s.sessions[tab] == 0 {
+		s.mu.Unlock()
+		return
+	}
+	changed := false
+	if event.Type == PresenceLeave {
+		changed = s.remove(room, tab)
+	} else {
+		changed = s.touch(room, tab)
+	}
+	roster := s.roster(room)
+	s.mu.Unlock()
+
+	if changed {
+		s.publish(roster)
+	}
+	if msg.Reply != "" {
+		if data, err := json.Marshal(roster); err == nil {
+			_ = msg.Respond(data)
+		}
+	}
+}
+
+// touch records a join or heartbeat, it returns true when the tab was not in the room.
+func (s *PresenceServer) touch(room string, tab presenceTab) bool {
+	tabs := s.rooms[room]
+	if tabs == nil {
+		tabs = map[presenceTab]*presenceEntry{}
+		s.rooms[room] = tabs
+	}
+	now := time.Now()
+	if entry := tabs[tab]; entry != nil {
+		entry.lastSeen = now
+		return false
+	}
+	tabs[tab] = &presenceEntry{joined: now, lastSeen: now}
+	return true
+}
+
+func (s *PresenceServer) remove(room string, tab presenceTab) bool {
+	tabs := s.rooms[room]
+	if tabs[tab] == nil {
+		return false
+	}
+	delete(tabs, tab)
+	if len(tabs) == 0 {
+		delete(s.rooms, room)
+	}
+	return true
+}
+
+func (s *PresenceServer) roster(room string) PresenceRoster {
+	members := map[string]*PresenceMember{}
+	for tab, entry := range s.rooms[room] {
+		member := members[tab.clientName]
+		if member == nil {
+			member = &PresenceMember{ClientName: tab.clientName, Since: entry.joined}
+			members[tab.clientName] = member
+		}
+		member.Tabs++
+		if entry.joined.Before(member.Since) {
+			member.Since = entry.joined
+		}
+	}
+
+	roster := PresenceRoster{Room: room, Members: []PresenceMember{}}
+	for _, member := range members {
+		roster.Members = append(roster.Members, *member)
+	}
+	sort.Slice(roster.Members, func(i, j int) bool {
+		return roster.Members[i].ClientName < roster.Members[j].ClientName
+	})
+	return roster
+}
+
+func (s *PresenceServer) publish(roster PresenceRoster) {
+	if data, err := json.Marshal(roster); err == nil {
+		_ = s.conn.Publish(presenceRosterSubject(roster.Room), data)
+	}
+}
+
+// Roster returns the members of room.
+func (s *PresenceServer) Roster(room string) PresenceRoster {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.roster(room)
+}
+
+func (s *PresenceServer) expire() {
+	ticker := time.NewTicker(s.timeout / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.removeTabs(func(tab presenceTab, entry *presenceEntry) bool {
+				return time.Since(entry.lastSeen) > s.timeout
+			})
+		}
+	}
+}
+
+// removeTabs removes the tabs matching remove from all rooms and publishes the changed rosters.
+func (s *PresenceServer) removeTabs(remove func(tab presenceTab, entry *presenceEntry) bool) {
+	var changed []PresenceRoster
+	s.mu.Lock()
+	for room, tabs := range s.rooms {
+		removed := false
+		for tab, entry := range tabs {
+			if remove(tab, entry) {
+				removed = s.remove(room, tab) || removed
+			}
+		}
+		if removed {
+			changed = append(changed, s.roster(room))
+		}
+	}
+	s.mu.Unlock()
+
+	for _, roster := range changed {
+		s.publish(roster)
+	}
+}
+
+// SessionStarted accepts the PresenceEvents of the tab, it joins rooms with its own events.
+func (s *PresenceServer) SessionStarted(clientId, tabId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sessions == nil {
+		s.sessions = map[presenceTab]int{}
+	}
+	s.sessions[presenceTab{clientName: clientId, tabId: tabId}]++
+}
+
+// SessionEnded removes the tab of the ended Proxy session from all rooms.
+func (s *PresenceServer) SessionEnded(clientId, tabId string) {
+	s.mu.Lock()
+	tab := presenceTab{clientName: clientId, tabId: tabId}
+	if s.sessions[tab] > 1 {
+		s.sessions[tab]--
+	} else {
+		delete(s.sessions, tab)
+	}
+	s.mu.Unlock()
+
+	s.removeTabs(func(tab presenceTab, _ *presenceEntry) bool {
+		return tab.clientName == clientId && tab.tabId == tabId
+	})
+}
@@ -0,0 +1,119 @@
+//go:build !wasm
+
+package natsws
+
+import (
+	"encoding/json"
+	"github.com/nats-io/nats.go"
+	"testing"
+	"time"
+)
+
+func TestPresenceServer(t *testing.T) {
+	natsServer, _ := runNatsServer(t)
+	conn, err := nats.Connect(natsServer.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	presence, err := StartPresenceServer(conn, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer presence.Stop()
+
+	rosters, err := conn.SubscribeSync(presenceRosterSubject("lobby"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	announce := func(event PresenceEvent) (roster PresenceRoster) {
+		data, _ := json.Marshal(event)
+		msg, err := conn.Request(presenceSubject("lobby"), data, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = json.Unmarshal(msg.Data, &roster); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	announce(PresenceEvent{Type: PresenceJoin, ClientName: "alice", TabId: "1"})
+	announce(PresenceEvent{Type: PresenceJoin, ClientName: "alice", TabId: "2"})
+	roster := announce(PresenceEvent{Type: PresenceJoin, ClientName: "bob", TabId: "3"})
+	if len(roster.Members) != 2 || roster.Members[0].ClientName != "alice" || roster.Members[0].Tabs != 2 {
+		t.Fatalf("unexpected roster %+v", roster)
+	}
+
+	presence.SessionEnded("bob", "3")
+	roster = presence.Roster("lobby")
+	if len(roster.Members) != 1 || roster.Members[0].ClientName != "alice" {
+		t.Fatalf("expected bob to be removed, got %+v", roster)
+	}
+
+	// one roster update for each join and one for the ended session
+	for i := 0; i < 4; i++ {
+		if _, err = rosters.NextMsg(time.Second); err != nil {
+			t.Fatalf("roster update %d: %v", i, err)
+		}
+	}
+
+	// Stop is deferred as well
+	presence.Stop()
+}
+
+func TestPresenceServerSessions(t *testing.T) {
+	natsServer, _ := runNatsServer(t)
+	conn, err := nats.Connect(natsServer.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	presence, err := StartPresenceServer(conn, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer presence.Stop()
+
+	announce := func(event PresenceEvent) (roster PresenceRoster, err error) {
+		data, _ := json.Marshal(event)
+		var msg *nats.Msg
+		if msg, err = conn.Request(presenceSubject("lobby"), data, 200*time.Millisecond); err != nil {
+			return
+		}
+		err = json.Unmarshal(msg.Data, &roster)
+		return
+	}
+
+	// the Proxy authenticated alice in tab 1
+	presence.SessionStarted("alice", "1")
+	roster, err := announce(PresenceEvent{Type: PresenceJoin, ClientName: "alice", TabId: "1"})
+	if err != nil || len(roster.Members) != 1 || roster.Members[0].ClientName != "alice" {
+		t.Fatalf("unexpected roster %+v %v", roster, err)
+	}
+
+	// events of tabs without a session are ignored
+	for _, event := range []PresenceEvent{
+		{Type: PresenceJoin, ClientName: "mallory", TabId: "2"},
+		{Type: PresenceJoin, ClientName: "alice", TabId: "2"},
+		{Type: PresenceLeave, ClientName: "alice", TabId: "3"},
+	} {
+		if _, err = announce(event); err != nats.ErrTimeout {
+			t.Fatalf("expected %+v to be ignored, got %v", event, err)
+		}
+	}
+	if roster = presence.Roster("lobby"); len(roster.Members) != 1 || roster.Members[0].Tabs != 1 {
+		t.Fatalf("unexpected roster %+v", roster)
+	}
+
+	presence.SessionEnded("alice", "1")
+	if roster = presence.Roster("lobby"); len(roster.Members) != 0 {
+		t.Fatalf("expected alice to be removed, got %+v", roster)
+	}
+	if _, err = announce(PresenceEvent{Type: PresenceJoin, ClientName: "alice", TabId: "1"}); err != nats.ErrTimeout {
+		t.Fatalf("expected the event of the ended session to be ignored, got %v", err)
+	}
+}
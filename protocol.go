@@ -0,0 +1,74 @@
+package natsws
+
+import (
+	"bytes"
+	"github.com/nats-io/nats.go"
+	"strconv"
+	"strings"
+)
+
+// protocolOp is a nats protocol operation parsed from a proxied websocket frame.
+type protocolOp struct {
+	Name    string
+	Subject string
+	Header  nats.Header
+	Payload []byte
+}
+
+// parseProtocol parses the nats protocol operations in a websocket frame.
+//
+// Parsing is best effort, an operation split across frames ends parsing of the frame.
+func parseProtocol(data []byte) (ops []protocolOp) {
+	for len(data) > 0 {
+		end := bytes.Index(data, []byte("\r\n"))
+		if end < 0 {
+			return
+		}
+		fields := strings.Fields(string(data[:end]))
+		data = data[end+2:]
+		if len(fields) == 0 {
+			continue
+		}
+
+		op := protocolOp{Name: strings.ToUpper(fields[0])}
+		var headerSize, totalSize int
+		var err error
+		switch op.Name {
+		case "PUB", "MSG":
+			if len(fields) < 3 {
+				return
+			}
+			op.Subject = fields[1]
+			totalSize, err = strconv.Atoi(fields[len(fields)-1])
+		case "HPUB", "HMSG":
+			if len(fields) < 4 {
+				return
+			}
+			op.Subject = fields[1]
+			if headerSize, err = strconv.Atoi(fields[len(fields)-2]); err == nil {
+				totalSize, err = strconv.Atoi(fields[len(fields)-1])
+			}
+		case "SUB", "UNSUB":
+			if len(fields) > 1 {
+				op.Subject = fields[1]
+			}
+			ops = append(ops, op)
+			continue
+		default:
+			ops = append(ops, op)
+			continue
+		}
+
+		// sizes come from the client, reject them before slicing
+		if err != nil || headerSize < 0 || headerSize > totalSize || totalSize > len(data)-2 {
+			return
+		}
+		if headerSize > 0 {
+			op.Header, _ = nats.DecodeHeadersMsg(data[:headerSize])
+		}
+		op.Payload = data[headerSize:totalSize]
+		data = data[totalSize+2:]
+		ops = append(ops, op)
+	}
+	return
+}
@@ -0,0 +1,47 @@
+package natsws
+
+import (
+	"testing"
+)
+
+func TestParseProtocol(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame string
+		ops   []string
+	}{
+		{"pub", "PUB a 5\r\nhello\r\n", []string{"PUB a hello"}},
+		{"pub with reply", "PUB a b 2\r\nhi\r\nPING\r\n", []string{"PUB a hi", "PING  "}},
+		{"hpub", "HPUB a 12 14\r\nNATS/1.0\r\n\r\nhi\r\n", []string{"HPUB a hi"}},
+		{"msg", "MSG a 1 2\r\nhi\r\n", []string{"MSG a hi"}},
+		{"sub", "SUB a 1\r\nUNSUB 1\r\n", []string{"SUB a ", "UNSUB 1 "}},
+		{"empty lines", "\r\n\r\nPONG\r\n", []string{"PONG  "}},
+		{"no line end", "PUB a 5", nil},
+		{"truncated payload", "PUB a 5\r\nhel", nil},
+		{"payload without line end", "PUB a 5\r\nhello", nil},
+		{"missing size", "PUB a\r\nhello\r\n", nil},
+		{"size not a number", "PUB a five\r\nhello\r\n", nil},
+		{"negative size", "PUB a -3\r\nhello\r\n", nil},
+		{"negative header size", "HPUB a -3 0\r\n\r\n", nil},
+		{"negative sizes", "HPUB a -3 -1\r\n\r\n", nil},
+		{"header larger than total", "HPUB a 5 2\r\nhello\r\n", nil},
+		{"huge size", "PUB a 9223372036854775807\r\nhello\r\n", nil},
+		{"ops before a malformed one", "PING\r\nHPUB a -3 0\r\n\r\n", []string{"PING  "}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var ops []string
+			for _, op := range parseProtocol([]byte(test.frame)) {
+				ops = append(ops, op.Name+" "+op.Subject+" "+string(op.Payload))
+			}
+			if len(ops) != len(test.ops) {
+				t.Fatalf("expected %q, got %q", test.ops, ops)
+			}
+			for i := range ops {
+				if ops[i] != test.ops[i] {
+					t.Fatalf("expected %q, got %q", test.ops, ops)
+				}
+			}
+		})
+	}
+}
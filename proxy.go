@@ -4,23 +4,67 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"github.com/nats-io/nats.go"
 	"math/rand"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"nhooyr.io/websocket"
+	"path"
+	"strconv"
 	"strings"
 )
 
 var _ http.Handler = (*Proxy)(nil)
 
+// DefaultReadLimit is the read limit of a Proxy without ReadLimit, the websocket library default.
+const DefaultReadLimit = 32768
+
+// ObjectReadLimit is a Proxy.ReadLimit for Object Store uploads and other large messages of
+// nats clients other than Connection, which splits its writes to fit the DefaultReadLimit.
+// Messages from the backend are always read up to ObjectReadLimit.
+//
+// A nats client writes its buffered protocol operations as one websocket message. The buffer
+// is flushed once it holds 32KiB, so a message can hold up to 32KiB of operations plus the
+// operation that crossed the limit. ObjectReadLimit fits a payload of the nats server default
+// max_payload of 1MiB together with a full buffer. The User-Agent of the client is forwarded to
+// the backend, for browsers the nats server limits its websocket frames to 4KiB.
+const ObjectReadLimit = 1<<20 + 64<<10
+
 type Proxy struct {
 	Context context.Context
 	Manager Manager
 
-	proxyContext context.Context
-	proxyCancel  context.CancelFunc
+	// Recorder, when set, selects the sessions to record by clientId.
+	// See RecordedFrame for the recording format.
+	Recorder Recorder
+
+	// Tracer, when set, creates a span for each proxied session.
+	Tracer *Tracer
+	// TraceMessages additionally creates a span for each published or delivered message.
+	TraceMessages bool
+
+	// ReadLimit is the largest websocket message in bytes read from the client, DefaultReadLimit
+	// when zero, and from the backend when larger than ObjectReadLimit. Set it to ObjectReadLimit
+	// for clients other than Connection that send messages larger than about 32KiB, for example
+	// Object Store chunks.
+	ReadLimit int64
+
+	// Sessions, when set, is notified when websocket sessions start and end,
+	// for example a PresenceServer.
+	Sessions SessionListener
+}
+
+// proxySession holds the state of a single proxied websocket connection.
+type proxySession struct {
+	clientId  string
+	tabId     string
+	recording *sessionRecording
+	span      *Span
+	// ctx is cancelled to end the session
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 func (p *Proxy) pickNatsURL() string {
@@ -59,6 +103,16 @@ func (p *Proxy) pickNatsURL() string {
 
 func (p *Proxy) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 
+	if path.Base(request.URL.Path) == IdentityPath && !strings.EqualFold(request.Header.Get("Upgrade"), "websocket") {
+		p.serveIdentity(writer, request)
+		return
+	}
+
+	clientId := path.Base(request.URL.Path)
+	if !p.checkClientName(writer, request, clientId) {
+		return
+	}
+
 	var natsUrl string
 	if natsUrl = p.pickNatsURL(); natsUrl == "" {
 		p.Manager.OnError("pickNatsURL", fmt.Errorf("none available"))
@@ -79,13 +133,16 @@ func (p *Proxy) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 	var client *websocket.Conn
 	var backend *websocket.Conn
 
-	if p.Context == nil {
-		p.Context = context.TODO()
+	parent := p.Context
+	if parent == nil {
+		parent = context.TODO()
 	}
 
-	p.proxyContext, p.proxyCancel = context.WithCancel(p.Context)
+	session := &proxySession{clientId: clientId, tabId: request.URL.Query().Get("tab")}
+	session.ctx, session.cancel = context.WithCancel(parent)
+	defer session.cancel()
 
-	if backend, _, err = websocket.Dial(p.proxyContext, natsUrl, nil); err != nil {
+	if backend, _, err = websocket.Dial(session.ctx, natsUrl, backendDialOptions(request)); err != nil {
 		p.Manager.OnError("Proxy websocket.Dial", err)
 		writer.WriteHeader(http.StatusServiceUnavailable)
 		return
@@ -99,11 +156,35 @@ func (p *Proxy) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 	}
 	defer func() { _ = client.Close(websocket.StatusNormalClosure, "") }()
 
+	if p.ReadLimit > 0 {
+		client.SetReadLimit(p.ReadLimit)
+	}
+	// the nats server limits its messages with max_payload, unlike clients it is trusted
+	backend.SetReadLimit(ObjectReadLimit)
+	if p.ReadLimit > ObjectReadLimit {
+		backend.SetReadLimit(p.ReadLimit)
+	}
+
+	if session.recording, err = p.startRecording(session.clientId); err != nil {
+		p.Manager.OnError("Proxy Recorder.Record", err)
+	}
+	defer session.recording.close()
+
+	if p.Sessions != nil {
+		p.Sessions.SessionStarted(session.clientId, session.tabId)
+		defer p.Sessions.SessionEnded(session.clientId, session.tabId)
+	}
+
+	session.span = p.Tracer.Start(SpanContext{}, "natsws.Proxy session")
+	session.span.SetAttribute("clientId", session.clientId)
+	session.span.SetAttribute("tabId", session.tabId)
+	session.span.SetAttribute("backend", natsUrl)
+
 	errClient := make(chan error, 1)
 	errBackend := make(chan error, 1)
 
-	go p.copyWebSocketFrames("client->backend", client, backend, errClient, errBackend)
-	go p.copyWebSocketFrames("client<-backend", backend, client, errBackend, errClient)
+	go p.copyWebSocketFrames(ClientToBackend, client, backend, session, errClient, errBackend)
+	go p.copyWebSocketFrames(BackendToClient, backend, client, session, errBackend, errClient)
 
 	var msg string
 	select {
@@ -115,7 +196,9 @@ func (p *Proxy) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 
 	switch websocket.CloseStatus(err) {
 	case websocket.StatusGoingAway, websocket.StatusNormalClosure:
+		session.span.Finish(nil)
 	default:
+		session.span.Finish(err)
 		if !strings.Contains(err.Error(), "failed to read frame header: EOF") {
 			p.Manager.OnError(msg, err)
 		}
@@ -123,10 +206,11 @@ func (p *Proxy) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 
 }
 
-func (p *Proxy) copyWebSocketFrames(direction string, from, to *websocket.Conn, fromChan chan<- error, toChan chan<- error) {
+func (p *Proxy) copyWebSocketFrames(direction string, from, to *websocket.Conn, session *proxySession,
+	fromChan chan<- error, toChan chan<- error) {
 
 	for {
-		messageType, bytes, err := from.Read(p.proxyContext)
+		messageType, bytes, err := from.Read(session.ctx)
 		if err != nil {
 			p.Manager.OnError(direction, err)
 			closeStatus := websocket.StatusNormalClosure
@@ -144,14 +228,16 @@ func (p *Proxy) copyWebSocketFrames(direction string, from, to *websocket.Conn,
 			_ = to.Close(closeStatus, closeMessage)
 			break
 		}
+		if recordErr := session.recording.frame(direction, messageType, bytes); recordErr != nil {
+			p.Manager.OnError("Proxy recording "+direction, recordErr)
+		}
+		if p.TraceMessages {
+			p.traceFrame(session, direction, bytes)
+		}
 		if p.Manager.IsDebug() {
-			fmt.Printf("%s : %q\n", direction, string(bytes))
-			// demo simulating a server disconnect
-			if string(bytes) == "PUB demo.disconnect 0\r\n\r\n" {
-				p.proxyCancel()
-			}
+			p.debugFrame(session, direction, bytes)
 		}
-		err = to.Write(p.proxyContext, messageType, bytes)
+		err = to.Write(session.ctx, messageType, bytes)
 		if err != nil {
 			toChan <- err
 			break
@@ -160,6 +246,41 @@ func (p *Proxy) copyWebSocketFrames(direction string, from, to *websocket.Conn,
 
 }
 
+func (p *Proxy) debugFrame(session *proxySession, direction string, frame []byte) {
+	fmt.Printf("%s : %q\n", direction, string(frame))
+	for _, op := range parseProtocol(frame) {
+		if len(op.Header) > 0 {
+			fmt.Printf("%s : %s %s headers %v\n", direction, op.Name, op.Subject, op.Header)
+		}
+		// demo simulating a server disconnect, with or without headers
+		if (op.Name == "PUB" || op.Name == "HPUB") && op.Subject == "demo.disconnect" {
+			session.cancel()
+		}
+	}
+}
+
+// traceFrame creates a span for each message in the frame, continuing the trace
+// from the message headers or the session trace when there are none.
+func (p *Proxy) traceFrame(session *proxySession, direction string, frame []byte) {
+	for _, op := range parseProtocol(frame) {
+		switch op.Name {
+		case "PUB", "HPUB", "MSG", "HMSG":
+		default:
+			continue
+		}
+		parent, ok := ExtractTrace(&nats.Msg{Header: op.Header})
+		if !ok && session.span != nil {
+			parent = session.span.SpanContext
+		}
+		span := p.Tracer.Start(parent, "natsws.Proxy "+op.Name+" "+op.Subject)
+		span.SetAttribute("clientId", session.clientId)
+		span.SetAttribute("direction", direction)
+		span.SetAttribute("subject", op.Subject)
+		span.SetAttribute("size", strconv.Itoa(len(op.Payload)))
+		span.Finish(nil)
+	}
+}
+
 func (p *Proxy) buildAcceptOptions(request *http.Request) *websocket.AcceptOptions {
 	var options *websocket.AcceptOptions
 	// https://github.com/gorilla/websocket/issues/731
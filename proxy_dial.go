@@ -0,0 +1,17 @@
+//go:build !wasm
+
+package natsws
+
+import (
+	"net/http"
+	"nhooyr.io/websocket"
+)
+
+// backendDialOptions forwards the User-Agent of the client to the backend.
+func backendDialOptions(request *http.Request) *websocket.DialOptions {
+	options := &websocket.DialOptions{HTTPHeader: http.Header{}}
+	if userAgent := request.Header.Get("User-Agent"); userAgent != "" {
+		options.HTTPHeader.Set("User-Agent", userAgent)
+	}
+	return options
+}
@@ -0,0 +1,11 @@
+package natsws
+
+import (
+	"net/http"
+	"nhooyr.io/websocket"
+)
+
+// backendDialOptions has no headers to set, the Proxy does not run in the browser.
+func backendDialOptions(_ *http.Request) *websocket.DialOptions {
+	return nil
+}
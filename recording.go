@@ -0,0 +1,204 @@
+package natsws
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"nhooyr.io/websocket"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const ClientToBackend = "client->backend"
+const BackendToClient = "client<-backend"
+
+// RecordedFrame is a single websocket frame of a recorded Proxy session.
+//
+// Recordings are newline delimited JSON with one RecordedFrame per line:
+//
+//	{"time":"2023-08-20T15:04:05.123456789Z","direction":"client->backend","type":"binary","data":"UElORw0K"}
+//
+// time is RFC 3339 with nanoseconds, direction is ClientToBackend or BackendToClient,
+// type is "text" or "binary" and data is the base64 encoded frame payload.
+type RecordedFrame struct {
+	Time      time.Time `json:"time"`
+	Direction string    `json:"direction"`
+	Type      string    `json:"type"`
+	Data      []byte    `json:"data"`
+}
+
+func (f RecordedFrame) messageType() websocket.MessageType {
+	if f.Type == "text" {
+		return websocket.MessageText
+	}
+	return websocket.MessageBinary
+}
+
+// Recorder selects which Proxy sessions are recorded.
+type Recorder interface {
+	// Record returns the destination for the session of clientId,
+	// or nil when the session should not be recorded.
+	Record(clientId string) (io.WriteCloser, error)
+}
+
+var _ Recorder = (*fileRecorder)(nil)
+
+// FileRecorder records the sessions of the provided clientIds to
+// files named <clientId>-<timestamp>.natsws in dir.
+func FileRecorder(dir string, clientIds ...string) Recorder {
+	r := &fileRecorder{dir: dir, clientIds: map[string]bool{}}
+	for _, id := range clientIds {
+		r.clientIds[id] = true
+	}
+	return r
+}
+
+type fileRecorder struct {
+	dir       string
+	clientIds map[string]bool
+}
+
+func (r *fileRecorder) Record(clientId string) (io.WriteCloser, error) {
+	if !r.clientIds[clientId] {
+		return nil, nil
+	}
+	name := fmt.Sprintf("%s-%s.natsws", clientId, time.Now().UTC().Format("20060102T150405.000000000"))
+	return os.Create(filepath.Join(r.dir, name))
+}
+
+type sessionRecording struct {
+	mu      sync.Mutex
+	writer  io.WriteCloser
+	encoder *json.Encoder
+}
+
+func (p *Proxy) startRecording(clientId string) (recording *sessionRecording, err error) {
+	if p.Recorder == nil {
+		return
+	}
+	var writer io.WriteCloser
+	if writer, err = p.Recorder.Record(clientId); err != nil || writer == nil {
+		return
+	}
+	recording = &sessionRecording{writer: writer, encoder: json.NewEncoder(writer)}
+	return
+}
+
+// frame records a single frame, recording stops after the first write error.
+func (s *sessionRecording) frame(direction string, messageType websocket.MessageType, data []byte) (err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.encoder == nil {
+		return
+	}
+
+	frame := RecordedFrame{Time: time.Now().UTC(), Direction: direction, Type: "binary", Data: data}
+	if messageType == websocket.MessageText {
+		frame.Type = "text"
+	}
+	if err = s.encoder.Encode(frame); err != nil {
+		s.encoder = nil
+	}
+	return
+}
+
+func (s *sessionRecording) close() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.encoder = nil
+	_ = s.writer.Close()
+}
+
+// ReadRecording reads the frames of a recording written by the Proxy.
+func ReadRecording(reader io.Reader) (frames []RecordedFrame, err error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var frame RecordedFrame
+		if err = json.Unmarshal(scanner.Bytes(), &frame); err != nil {
+			return
+		}
+		frames = append(frames, frame)
+	}
+	err = scanner.Err()
+	return
+}
+
+// ReplayClient dials the websocket at url, usually a test nats server, and writes the
+// ClientToBackend frames of a recording. When realtime is true the original spacing
+// between frames is preserved. Frames received from the server are discarded.
+func ReplayClient(ctx context.Context, url string, frames []RecordedFrame, realtime bool) (err error) {
+	var conn *websocket.Conn
+	if conn, _, err = websocket.Dial(ctx, url, nil); err != nil {
+		return
+	}
+	defer func() { _ = conn.Close(websocket.StatusNormalClosure, "") }()
+
+	go discardFrames(ctx, conn)
+
+	return replayFrames(ctx, conn, frames, ClientToBackend, realtime)
+}
+
+var _ http.Handler = (*ReplayServer)(nil)
+
+// ReplayServer plays back the BackendToClient frames of a recording
+// to each websocket client that connects, usually a test client.
+type ReplayServer struct {
+	Frames   []RecordedFrame
+	Realtime bool
+}
+
+func (s *ReplayServer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	conn, err := websocket.Accept(writer, request, nil)
+	if err != nil {
+		return
+	}
+	defer func() { _ = conn.Close(websocket.StatusNormalClosure, "") }()
+
+	go discardFrames(request.Context(), conn)
+
+	_ = replayFrames(request.Context(), conn, s.Frames, BackendToClient, s.Realtime)
+}
+
+func discardFrames(ctx context.Context, conn *websocket.Conn) {
+	for {
+		if _, _, err := conn.Read(ctx); err != nil {
+			return
+		}
+	}
+}
+
+func replayFrames(ctx context.Context, conn *websocket.Conn, frames []RecordedFrame, direction string, realtime bool) (err error) {
+	var last time.Time
+	for _, frame := range frames {
+		if frame.Direction != direction {
+			continue
+		}
+		if realtime && !last.IsZero() {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(frame.Time.Sub(last)):
+			}
+		}
+		last = frame.Time
+		if err = conn.Write(ctx, frame.messageType(), frame.Data); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,71 @@
+package natsws
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"nhooyr.io/websocket"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordingReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	recorder := FileRecorder(dir, "recorded")
+	if w, err := recorder.Record("other"); err != nil || w != nil {
+		t.Fatalf("expected no recording for other, got %v %v", w, err)
+	}
+	writer, err := recorder.Record("recorded")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recording := &sessionRecording{writer: writer, encoder: json.NewEncoder(writer)}
+	_ = recording.frame(BackendToClient, websocket.MessageBinary, []byte("INFO {}\r\n"))
+	_ = recording.frame(ClientToBackend, websocket.MessageText, []byte("PING\r\n"))
+	_ = recording.frame(BackendToClient, websocket.MessageBinary, []byte("PONG\r\n"))
+	recording.close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "recorded-*.natsws"))
+	if len(files) != 1 {
+		t.Fatalf("expected one recording, got %v", files)
+	}
+	file, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = file.Close() }()
+
+	frames, err := ReadRecording(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 3 || frames[1].Type != "text" || frames[1].Direction != ClientToBackend {
+		t.Fatalf("unexpected frames %+v", frames)
+	}
+
+	server := httptest.NewServer(&ReplayServer{Frames: frames})
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, strings.Replace(server.URL, "http", "ws", 1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close(websocket.StatusNormalClosure, "") }()
+
+	for _, expected := range []string{"INFO {}\r\n", "PONG\r\n"} {
+		_, data, readErr := conn.Read(ctx)
+		if readErr != nil {
+			t.Fatal(readErr)
+		}
+		if string(data) != expected {
+			t.Fatalf("expected %q got %q", expected, string(data))
+		}
+	}
+}
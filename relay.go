@@ -0,0 +1,510 @@
+package natsws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/maxence-charriere/go-app/v9/pkg/app"
+	"github.com/nats-io/nats.go"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrRelayed is returned for features that need the nats connection, such as JetStream,
+// in tabs relaying through another tab with Options.ShareAcrossTabs.
+var ErrRelayed = errors.New("natsws: not available in a tab relaying through another tab")
+
+// ErrRelayDropped is reported when messages from other tabs were dropped because this tab did not keep up.
+var ErrRelayDropped = fmt.Errorf("natsws: relay dropped messages: %w", nats.ErrSlowConsumer)
+
+// relayHeartbeatInterval is the interval of follower heartbeats, the leader tab removes the
+// subscriptions of a follower tab that missed relayExpireHeartbeats heartbeats.
+const relayHeartbeatInterval = 5 * time.Second
+
+const relayExpireHeartbeats = 3
+
+const relayBuffer = 256
+
+// Operations exchanged between tabs over the BroadcastChannel.
+const (
+	relayHello     = "hello"
+	relayLeader    = "leader"
+	relayState     = "state"
+	relayPublish   = "pub"
+	relayRequest   = "req"
+	relayReply     = "reply"
+	relaySub       = "sub"
+	relayUnsub     = "unsub"
+	relayMsg       = "msg"
+	relayPing      = "ping"
+	relayResub     = "resub"
+	relayLatency   = "latency"
+	relayReconnect = "reconnect"
+	relayBye       = "bye"
+)
+
+type relayMessage struct {
+	Op        string        `json:"op"`
+	From      string        `json:"from"`
+	To        string        `json:"to,omitempty"`
+	ID        string        `json:"id,omitempty"`
+	Subject   string        `json:"subject,omitempty"`
+	Queue     string        `json:"queue,omitempty"`
+	Reply     string        `json:"reply,omitempty"`
+	Header    nats.Header   `json:"header,omitempty"`
+	Data      []byte        `json:"data,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	Timeout   time.Duration `json:"timeout,omitempty"`
+	Connected bool          `json:"connected,omitempty"`
+	Reason    ChangeReason  `json:"reason,omitempty"`
+	Latency   time.Duration `json:"latency,omitempty"`
+}
+
+// relay shares one nats connection between the tabs of a browser.
+//
+// The tab holding the Web Lock of the connection is the leader and owns the nats
+// connection, the other tabs relay publish, subscribe and request calls to the
+// leader over a BroadcastChannel. When the leader tab closes the lock passes to
+// another tab, which connects and asks the remaining tabs to subscribe again.
+type relay struct {
+	c         *Connection
+	channel   app.Value
+	onMessage app.Func
+	release   func()
+	incoming  chan relayMessage
+	// dropped counts messages that did not fit in incoming, dropping is set until the drop is reported
+	dropped  uint64
+	dropping int32
+
+	mu        sync.Mutex
+	leader    bool
+	connected bool
+	nextId    int
+	requests  map[string]chan relayMessage
+	// leaderTab is the tab id of the leader a follower tab subscribed through
+	leaderTab string
+	// subscriptions the leader holds for follower tabs by tab id and subscription id
+	remote map[string]*remoteSub
+	// seen is when the leader last heard from each follower tab
+	seen map[string]time.Time
+}
+
+// remoteSub is a subscription the leader tab holds for a follower tab.
+type remoteSub struct {
+	from    string
+	id      string
+	subject string
+	queue   string
+	sub     *nats.Subscription
+}
+
+// startSharing starts leader election when Options.ShareAcrossTabs is set
+// and returns false when the browser does not support it.
+func (c *Connection) startSharing() bool {
+	if !c.options.ShareAcrossTabs || !app.IsClient || !app.Window().Get("BroadcastChannel").Truthy() {
+		return false
+	}
+
+	r := &relay{
+		c:        c,
+		incoming: make(chan relayMessage, relayBuffer),
+		requests: map[string]chan relayMessage{},
+		remote:   map[string]*remoteSub{},
+		seen:     map[string]time.Time{},
+	}
+
+	name := "natsws/" + StateKey(c.name) + "/" + c.ClientName()
+	release, ok := requestLeaderLock(name, func() { c.do(c.becomeLeader) })
+	if !ok {
+		return false
+	}
+	r.release = release
+	unlock := c.lock()
+	c.relay = r
+	unlock()
+
+	r.channel = app.Window().Get("BroadcastChannel").New(name)
+	r.onMessage = app.FuncOf(func(this app.Value, args []app.Value) any {
+		var msg relayMessage
+		if err := json.Unmarshal([]byte(args[0].Get("data").String()), &msg); err != nil {
+			return nil
+		}
+		if msg.To == "" || msg.To == c.TabID() {
+			select {
+			case r.incoming <- msg:
+			default:
+				// a blocked tab must not stall the browser event loop
+				r.drop()
+			}
+		}
+		return nil
+	})
+	r.channel.Set("onmessage", r.onMessage)
+
+	go r.loop()
+	r.post(relayMessage{Op: relayHello})
+	return true
+}
+
+// becomeLeader connects after this tab acquired the leader lock, connect announces the leadership.
+func (c *Connection) becomeLeader() {
+	c.relay.mu.Lock()
+	c.relay.leader = true
+	c.relay.mu.Unlock()
+
+	c.connect()
+}
+
+// leaderConnected subscribes for the follower tabs on conn, a new nats connection of the
+// leader tab, and announces the leadership so that followers that joined since subscribe.
+func (r *relay) leaderConnected(conn *nats.Conn) {
+	if r == nil || r.isFollower() {
+		return
+	}
+	r.mu.Lock()
+	for _, remote := range r.remote {
+		r.subscribeRemote(conn, remote)
+	}
+	r.mu.Unlock()
+	r.post(relayMessage{Op: relayLeader})
+}
+
+// subscribeRemote replaces the subscription of remote with one on conn, r.mu must be held.
+func (r *relay) subscribeRemote(conn *nats.Conn, remote *remoteSub) {
+	if remote.sub != nil {
+		_ = remote.sub.Unsubscribe()
+	}
+	sub, err := conn.QueueSubscribe(remote.subject, remote.queue, func(m *nats.Msg) {
+		r.post(relayMessage{Op: relayMsg, To: remote.from, ID: remote.id, Subject: m.Subject, Reply: m.Reply, Header: m.Header, Data: m.Data})
+	})
+	if err != nil {
+		remote.sub = nil
+		return
+	}
+	remote.sub = sub
+}
+
+// hasFollowers reports if this tab is the leader of other tabs.
+func (r *relay) hasFollowers() bool {
+	if r == nil || r.isFollower() {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.seen) > 0
+}
+
+// drop counts a message from another tab that did not fit in the incoming buffer
+// and reports it to the Connection once per burst of drops.
+func (r *relay) drop() {
+	atomic.AddUint64(&r.dropped, 1)
+	if atomic.CompareAndSwapInt32(&r.dropping, 0, 1) {
+		r.c.do(func() {
+			atomic.StoreInt32(&r.dropping, 0)
+			r.c.setError(fmt.Errorf("%w, %d in total", ErrRelayDropped, atomic.LoadUint64(&r.dropped)))
+		})
+	}
+}
+
+// droppedCount returns the number of messages from other tabs that were dropped.
+func (r *relay) droppedCount() uint64 {
+	if r == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&r.dropped)
+}
+
+func (r *relay) isFollower() bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !r.leader
+}
+
+func (r *relay) isConnected() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.connected
+}
+
+func (r *relay) post(msg relayMessage) {
+	msg.From = r.c.TabID()
+	if data, err := json.Marshal(msg); err == nil {
+		r.channel.Call("postMessage", string(data))
+	}
+}
+
+func (r *relay) loop() {
+	ticker := time.NewTicker(relayHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.c.ctx().Done():
+			return
+		case <-r.c.done:
+			return
+		case msg := <-r.incoming:
+			if r.isFollower() {
+				r.follower(msg)
+			} else {
+				r.leaderMsg(msg)
+			}
+		case <-ticker.C:
+			if r.isFollower() {
+				r.post(relayMessage{Op: relayPing})
+			} else {
+				r.expireFollowers()
+			}
+		}
+	}
+}
+
+func (r *relay) follower(msg relayMessage) {
+	switch msg.Op {
+	case relayLeader:
+		r.mu.Lock()
+		changed := r.leaderTab != msg.From
+		r.leaderTab = msg.From
+		r.mu.Unlock()
+		if changed {
+			// a new leader holds none of the subscriptions of this tab, the same
+			// leader subscribes again by itself after replacing its nats connection
+			r.c.subs.relay(r)
+		}
+	case relayResub:
+		// the leader expired this tab and removed its subscriptions
+		r.c.subs.relay(r)
+	case relayState:
+		r.mu.Lock()
+		r.connected = msg.Connected
+		r.mu.Unlock()
+		r.c.do(func() { r.c.mirrorState(msg) })
+	case relayLatency:
+		if r.c.latency.add(msg.Latency) {
+			r.c.notifyLatency()
+		}
+	case relayMsg:
+		r.c.subs.deliver(msg.ID, &nats.Msg{Subject: msg.Subject, Reply: msg.Reply, Header: msg.Header, Data: msg.Data})
+	case relayReply:
+		r.mu.Lock()
+		ch := r.requests[msg.ID]
+		delete(r.requests, msg.ID)
+		r.mu.Unlock()
+		if ch != nil {
+			ch <- msg
+		}
+	}
+}
+
+func (r *relay) leaderMsg(msg relayMessage) {
+	conn := r.c.natsConnection()
+	if msg.Op != relayBye {
+		r.mu.Lock()
+		_, known := r.seen[msg.From]
+		r.seen[msg.From] = time.Now()
+		r.mu.Unlock()
+		if !known && msg.Op == relayPing {
+			// a follower tab that was expired while it was busy or suspended still
+			// expects its subscriptions, ask it to subscribe again
+			r.post(relayMessage{Op: relayResub, To: msg.From})
+		}
+	}
+	switch msg.Op {
+	case relayHello:
+		r.post(relayMessage{Op: relayState, To: msg.From, Connected: r.c.IsConnected(), Reason: r.c.ChangeReason()})
+	case relayReconnect:
+		r.c.ReconnectNow()
+	case relayPublish:
+		if conn != nil {
+			_ = conn.PublishMsg(&nats.Msg{Subject: msg.Subject, Reply: msg.Reply, Header: msg.Header, Data: msg.Data})
+		}
+	case relayRequest:
+		go r.request(conn, msg)
+	case relaySub:
+		// kept without a nats connection, leaderConnected subscribes once connected
+		key := msg.From + "/" + msg.ID
+		r.mu.Lock()
+		remote := r.remote[key]
+		if remote == nil {
+			remote = &remoteSub{from: msg.From, id: msg.ID}
+			r.remote[key] = remote
+		}
+		remote.subject, remote.queue = msg.Subject, msg.Queue
+		if conn != nil {
+			r.subscribeRemote(conn, remote)
+		}
+		r.mu.Unlock()
+	case relayUnsub:
+		r.mu.Lock()
+		if remote := r.remote[msg.From+"/"+msg.ID]; remote != nil {
+			if remote.sub != nil {
+				_ = remote.sub.Unsubscribe()
+			}
+			delete(r.remote, msg.From+"/"+msg.ID)
+		}
+		r.mu.Unlock()
+	case relayBye:
+		r.mu.Lock()
+		r.removeFollower(msg.From)
+		r.mu.Unlock()
+	}
+}
+
+// removeFollower removes the subscriptions of the follower tab, r.mu must be held.
+func (r *relay) removeFollower(tab string) {
+	for key, remote := range r.remote {
+		if remote.from == tab {
+			if remote.sub != nil {
+				_ = remote.sub.Unsubscribe()
+			}
+			delete(r.remote, key)
+		}
+	}
+	delete(r.seen, tab)
+}
+
+// expireFollowers removes the subscriptions of follower tabs that closed without saying bye.
+func (r *relay) expireFollowers() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for tab, seen := range r.seen {
+		if time.Since(seen) > relayExpireHeartbeats*relayHeartbeatInterval {
+			r.removeFollower(tab)
+		}
+	}
+}
+
+func (r *relay) request(conn *nats.Conn, msg relayMessage) {
+	reply := relayMessage{Op: relayReply, To: msg.From, ID: msg.ID}
+	if conn == nil {
+		reply.Error = nats.ErrConnectionClosed.Error()
+	} else if response, err := conn.RequestMsg(&nats.Msg{Subject: msg.Subject, Header: msg.Header, Data: msg.Data}, msg.Timeout); err != nil {
+		reply.Error = err.Error()
+	} else {
+		reply.Subject, reply.Header, reply.Data = response.Subject, response.Header, response.Data
+	}
+	r.post(reply)
+}
+
+func (r *relay) id() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextId++
+	return strconv.Itoa(r.nextId)
+}
+
+func (r *relay) publish(msg *nats.Msg) error {
+	if !r.isConnected() {
+		return fmt.Errorf("not connected")
+	}
+	r.post(relayMessage{Op: relayPublish, Subject: msg.Subject, Reply: msg.Reply, Header: msg.Header, Data: msg.Data})
+	return nil
+}
+
+func (r *relay) requestMsg(msg *nats.Msg, timeout time.Duration) (*nats.Msg, error) {
+	if !r.isConnected() {
+		return nil, fmt.Errorf("not connected")
+	}
+	id := r.id()
+	ch := make(chan relayMessage, 1)
+	r.mu.Lock()
+	r.requests[id] = ch
+	r.mu.Unlock()
+
+	r.post(relayMessage{Op: relayRequest, ID: id, Subject: msg.Subject, Header: msg.Header, Data: msg.Data, Timeout: timeout})
+
+	select {
+	case reply := <-ch:
+		if reply.Error != "" {
+			return nil, fmt.Errorf("%s", reply.Error)
+		}
+		return &nats.Msg{Subject: reply.Subject, Header: reply.Header, Data: reply.Data}, nil
+	case <-time.After(timeout):
+		r.mu.Lock()
+		delete(r.requests, id)
+		r.mu.Unlock()
+		return nil, nats.ErrTimeout
+	}
+}
+
+func (r *relay) subscribe(sub *subscription) {
+	r.post(relayMessage{Op: relaySub, ID: sub.id, Subject: sub.subject, Queue: sub.queue})
+}
+
+func (r *relay) unsubscribe(sub *subscription) {
+	r.post(relayMessage{Op: relayUnsub, ID: sub.id})
+}
+
+// broadcastState lets follower tabs mirror the state of the leader, snapshot is the state observers received.
+func (r *relay) broadcastState(snapshot *Connection) {
+	if r == nil || r.isFollower() {
+		return
+	}
+	r.post(relayMessage{Op: relayState, Connected: snapshot.IsConnected(), Reason: snapshot.changeReason})
+}
+
+// hide tells the leader tab that this follower tab is gone, the page may still return
+// from the back/forward cache, see show.
+func (r *relay) hide() {
+	if r.isFollower() {
+		r.post(relayMessage{Op: relayBye})
+	}
+}
+
+// show relays the subscriptions of a follower tab restored from the back/forward cache again.
+func (r *relay) show() {
+	if r.isFollower() {
+		r.c.subs.relay(r)
+		r.post(relayMessage{Op: relayHello})
+	}
+}
+
+// broadcastLatency shares a latency sample of the leader with the follower tabs.
+func (r *relay) broadcastLatency(rtt time.Duration) {
+	if r == nil || r.isFollower() {
+		return
+	}
+	r.post(relayMessage{Op: relayLatency, Latency: rtt})
+}
+
+// stop releases the leader lock and tells the other tabs this tab is gone.
+func (r *relay) stop() {
+	if r == nil {
+		return
+	}
+	if r.isFollower() {
+		r.post(relayMessage{Op: relayBye})
+	} else {
+		r.post(relayMessage{Op: relayState, Reason: Disconnect})
+	}
+	r.release()
+	r.channel.Call("close")
+	r.onMessage.Release()
+}
+
+// mirrorState applies the state of the leader tab on a follower tab.
+func (c *Connection) mirrorState(msg relayMessage) {
+	if msg.Reason == "" {
+		return
+	}
+	// observers subscribe on Connect, report it once per tab
+	c.update(func() bool {
+		c.changeReason = msg.Reason
+		if msg.Connected && !c.connectedOnce {
+			c.changeReason = Connect
+		} else if msg.Reason == Connect {
+			c.changeReason = Reconnect
+		}
+		if msg.Connected {
+			c.connectedOnce = true
+		}
+		return true
+	})
+	if msg.Connected {
+		c.flushOutbox()
+	}
+}
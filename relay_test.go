@@ -0,0 +1,113 @@
+//go:build !wasm
+
+package natsws
+
+import (
+	"encoding/json"
+	"github.com/maxence-charriere/go-app/v9/pkg/app"
+	"github.com/nats-io/nats.go"
+	"testing"
+	"time"
+)
+
+// relayChannel stands in for the BroadcastChannel shared by the tabs.
+type relayChannel struct {
+	app.Value
+	tabs []*relay
+}
+
+func (ch *relayChannel) Call(m string, args ...any) app.Value {
+	var msg relayMessage
+	if err := json.Unmarshal([]byte(args[0].(string)), &msg); err != nil {
+		panic(err)
+	}
+	for _, r := range ch.tabs {
+		if r.c.TabID() != msg.From && (msg.To == "" || msg.To == r.c.TabID()) {
+			r.incoming <- msg
+		}
+	}
+	return nil
+}
+
+func newRelayTab(ch *relayChannel, tabId string, leader bool) *relay {
+	c := newConnection(nil, "")
+	c.tabId = tabId
+	c.relay = &relay{c: c, channel: ch, incoming: make(chan relayMessage, relayBuffer), leader: leader,
+		connected: true, requests: map[string]chan relayMessage{}, remote: map[string]*remoteSub{},
+		seen: map[string]time.Time{}}
+	ch.tabs = append(ch.tabs, c.relay)
+	return c.relay
+}
+
+// handle runs the relay messages of the tabs until none arrive for a while.
+func (ch *relayChannel) handle() {
+	for {
+		handled := false
+		for _, r := range ch.tabs {
+			select {
+			case msg := <-r.incoming:
+				handled = true
+				if r.isFollower() {
+					r.follower(msg)
+				} else {
+					r.leaderMsg(msg)
+				}
+			case <-time.After(50 * time.Millisecond):
+			}
+		}
+		if !handled {
+			return
+		}
+	}
+}
+
+func TestRelayExpiredFollowerResubscribes(t *testing.T) {
+	natsServer, _ := runNatsServer(t)
+	nc, err := nats.Connect(natsServer.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+
+	ch := &relayChannel{}
+	leader := newRelayTab(ch, "leader", true)
+	leader.c.natsConn = nc
+	follower := newRelayTab(ch, "follower", false)
+
+	received := make(chan *nats.Msg, 10)
+	if _, err = follower.c.Subscribe("relay.expire", func(msg *nats.Msg) { received <- msg }); err != nil {
+		t.Fatal(err)
+	}
+	ch.handle()
+
+	expectMsg := func(data string) {
+		t.Helper()
+		if err := nc.Publish("relay.expire", []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		_ = nc.Flush()
+		ch.handle()
+		select {
+		case msg := <-received:
+			if string(msg.Data) != data {
+				t.Fatalf("expected %q, got %q", data, msg.Data)
+			}
+		default:
+			t.Fatalf("expected %q to reach the follower", data)
+		}
+	}
+	expectMsg("before")
+
+	// the follower missed its heartbeats, for example while the tab was frozen
+	leader.mu.Lock()
+	leader.seen["follower"] = time.Now().Add(-2 * relayExpireHeartbeats * relayHeartbeatInterval)
+	leader.mu.Unlock()
+	leader.expireFollowers()
+	if leader.hasFollowers() || len(leader.remote) != 0 {
+		t.Fatalf("expected the follower to expire, remote %v", leader.remote)
+	}
+
+	follower.post(relayMessage{Op: relayPing})
+	ch.handle()
+	expectMsg("after")
+}
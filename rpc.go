@@ -0,0 +1,145 @@
+package natsws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+	"time"
+)
+
+// Error codes of RPCError set by Handle.
+const (
+	RPCBadRequest    = "400"
+	RPCInternalError = "500"
+)
+
+// rpcInternalDescription is sent by Handle for errors without a description.
+const rpcInternalDescription = "internal error"
+
+// ServiceDesc describes an RPC service shared by the backend and the browser.
+//
+//	var Orders = &natsws.ServiceDesc{Name: "orders", Version: "1.0.0"}
+//	var CreateOrder = natsws.NewMethod[CreateRequest, CreateResponse](Orders, "create")
+type ServiceDesc struct {
+	Name        string
+	Version     string
+	Description string
+	// Subject is the subject prefix of the methods, Name when empty.
+	Subject string
+	// ErrorHandler is called on the backend for errors of the service, including
+	// responses Handle failed to send. It is the micro.Config ErrorHandler of AddService.
+	ErrorHandler micro.ErrHandler
+}
+
+func (d *ServiceDesc) subject() string {
+	if d.Subject != "" {
+		return d.Subject
+	}
+	return d.Name
+}
+
+// Method is a typed method of a service. Requests and responses are encoded as JSON.
+type Method[Req, Resp any] struct {
+	Service *ServiceDesc
+	Name    string
+}
+
+// NewMethod describes the method name of service, it is requested on the subject prefix of the service plus name.
+func NewMethod[Req, Resp any](service *ServiceDesc, name string) Method[Req, Resp] {
+	return Method[Req, Resp]{Service: service, Name: name}
+}
+
+// Subject returns the subject the method is requested on.
+func (m Method[Req, Resp]) Subject() string {
+	return m.Service.subject() + "." + m.Name
+}
+
+// RPCError is an error returned by a method handler. It is sent in the
+// Nats-Service-Error and Nats-Service-Error-Code headers of the response.
+type RPCError struct {
+	Code        string
+	Description string
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc error %s: %s", e.Code, e.Description)
+}
+
+// Requester sends requests, it is implemented by Connection and *nats.Conn.
+type Requester interface {
+	RequestMsg(msg *nats.Msg, timeout time.Duration) (*nats.Msg, error)
+}
+
+var _ Requester = (*Connection)(nil)
+var _ Requester = (*nats.Conn)(nil)
+
+// Call requests the method with request and decodes the response. Errors of the handler are returned as *RPCError.
+func (m Method[Req, Resp]) Call(requester Requester, request Req, timeout time.Duration) (response Resp, err error) {
+	var data []byte
+	if data, err = json.Marshal(request); err != nil {
+		return
+	}
+
+	var msg *nats.Msg
+	if msg, err = requester.RequestMsg(NewMsg(m.Subject(), data, ContentTypeHeader, "application/json"), timeout); err != nil {
+		return
+	}
+	if description := HeaderValue(msg, micro.ErrorHeader); description != "" {
+		return response, &RPCError{Code: HeaderValue(msg, micro.ErrorCodeHeader), Description: description}
+	}
+	err = json.Unmarshal(msg.Data, &response)
+	return
+}
+
+// AddService creates the nats micro service of desc on the backend, add its methods with Handle.
+func AddService(conn *nats.Conn, desc *ServiceDesc) (micro.Service, error) {
+	return micro.AddService(conn, micro.Config{Name: desc.Name, Version: desc.Version, Description: desc.Description,
+		ErrorHandler: desc.ErrorHandler})
+}
+
+// Handle adds method to service with handler. The micro.Request gives access to the headers of the request.
+//
+// An *RPCError returned by handler is sent as is. Other errors may hold backend details, they
+// are sent with code RPCInternalError and a generic description and reported to the
+// ErrorHandler of the ServiceDesc. An empty Code is sent as RPCInternalError and an empty
+// Description as the generic description, micro does not respond to errors without them.
+// Responses that fail to send are reported to the ErrorHandler as well.
+func Handle[Req, Resp any](service micro.Service, method Method[Req, Resp],
+	handler func(request micro.Request, in Req) (Resp, error)) error {
+
+	report := func(request micro.Request, err error) {
+		if err != nil && method.Service.ErrorHandler != nil {
+			method.Service.ErrorHandler(service, &micro.NATSError{Subject: request.Subject(), Description: err.Error()})
+		}
+	}
+
+	group := service.AddGroup(method.Service.subject())
+	return group.AddEndpoint(method.Name, micro.HandlerFunc(func(request micro.Request) {
+		var in Req
+		if err := json.Unmarshal(request.Data(), &in); err != nil {
+			report(request, request.Error(RPCBadRequest, err.Error(), nil))
+			return
+		}
+
+		out, err := handler(request, in)
+		if err != nil {
+			var rpcErr *RPCError
+			if !errors.As(err, &rpcErr) {
+				report(request, err)
+				rpcErr = &RPCError{Code: RPCInternalError}
+			}
+			code, description := rpcErr.Code, rpcErr.Description
+			if code == "" {
+				code = RPCInternalError
+			}
+			if description == "" {
+				description = rpcInternalDescription
+			}
+			report(request, request.Error(code, description, nil))
+			return
+		}
+		report(request, request.RespondJSON(out, micro.WithHeaders(micro.Headers{ContentTypeHeader: {"application/json"}})))
+	}))
+}
@@ -0,0 +1,93 @@
+//go:build !wasm
+
+package natsws
+
+import (
+	"errors"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+	"testing"
+	"time"
+)
+
+type sumRequest struct {
+	Values []int
+}
+
+type sumResponse struct {
+	Sum int
+}
+
+func TestRPC(t *testing.T) {
+	natsServer, _ := runNatsServer(t)
+	conn, err := nats.Connect(natsServer.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	reported := make(chan *micro.NATSError, 1)
+	desc := &ServiceDesc{Name: "math", Version: "1.0.0", ErrorHandler: func(_ micro.Service, err *micro.NATSError) {
+		reported <- err
+	}}
+	sum := NewMethod[sumRequest, sumResponse](desc, "sum")
+
+	service, err := AddService(conn, desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = service.Stop() }()
+
+	err = Handle(service, sum, func(request micro.Request, in sumRequest) (out sumResponse, err error) {
+		if len(in.Values) == 0 {
+			return out, &RPCError{Code: "422", Description: "no values"}
+		}
+		if in.Values[0] < 0 {
+			return out, &RPCError{}
+		}
+		if in.Values[0] == 0 {
+			return out, errors.New("query sums at db.internal:5432 failed")
+		}
+		for _, v := range in.Values {
+			out.Sum += v
+		}
+		return
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sum.Subject() != "math.sum" {
+		t.Fatalf("unexpected subject %q", sum.Subject())
+	}
+
+	response, err := sum.Call(conn, sumRequest{Values: []int{1, 2, 3}}, time.Second)
+	if err != nil || response.Sum != 6 {
+		t.Fatalf("expected 6, got %v %v", response, err)
+	}
+
+	var rpcErr *RPCError
+	if _, err = sum.Call(conn, sumRequest{}, time.Second); !errors.As(err, &rpcErr) || rpcErr.Code != "422" {
+		t.Fatalf("expected RPCError 422, got %v", err)
+	}
+
+	// micro does not respond to an error without code or description
+	if _, err = sum.Call(conn, sumRequest{Values: []int{-1}}, time.Second); !errors.As(err, &rpcErr) ||
+		rpcErr.Code != RPCInternalError || rpcErr.Description != rpcInternalDescription {
+		t.Fatalf("expected RPCError %s, got %v", RPCInternalError, err)
+	}
+
+	// other errors are reported on the backend and not sent to the caller
+	if _, err = sum.Call(conn, sumRequest{Values: []int{0}}, time.Second); !errors.As(err, &rpcErr) ||
+		rpcErr.Code != RPCInternalError || rpcErr.Description != rpcInternalDescription {
+		t.Fatalf("expected RPCError %s, got %v", RPCInternalError, err)
+	}
+	select {
+	case natsErr := <-reported:
+		if natsErr.Subject != "math.sum" || natsErr.Description != "query sums at db.internal:5432 failed" {
+			t.Fatalf("unexpected reported error %+v", natsErr)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the error to be reported")
+	}
+}
@@ -0,0 +1,143 @@
+package natsws
+
+import (
+	"github.com/maxence-charriere/go-app/v9/pkg/app"
+	"github.com/nats-io/nats.go"
+	"sync"
+	"time"
+)
+
+// Stats is a snapshot of the statistics of a Connection.
+type Stats struct {
+	Time time.Time
+	// Statistics of all nats connections of the Connection. Reconnects includes
+	// the connections replaced after being suspended or by ReconnectNow.
+	nats.Statistics
+	// Started is when the Connection started.
+	Started time.Time
+	// Connected is when the Connection last connected or reconnected.
+	Connected      time.Time
+	ConnectedUrl   string
+	ServerName     string
+	Latency        time.Duration
+	AverageLatency time.Duration
+	Subscriptions  []SubscriptionInfo
+	// RelayDropped counts messages from other tabs that were dropped because this tab
+	// did not keep up, see Options.ShareAcrossTabs.
+	RelayDropped uint64
+}
+
+// StatsKey returns the go-app state key of the periodic Stats of the Connection with name,
+// see Options.StatsInterval.
+func StatsKey(name string) string {
+	return StateKey(name) + ".stats"
+}
+
+// ObserveStats observes the periodic Stats of the Connection of the Component with the same Name.
+func ObserveStats(ctx app.Context, name string, stats *Stats) app.Observer {
+	observer := ctx.ObserveState(StatsKey(name))
+	observer.Value(stats)
+	return observer
+}
+
+// connectionStats holds the statistics shared by all copies of a Connection.
+type connectionStats struct {
+	mu        sync.Mutex
+	replaced  nats.Statistics
+	started   time.Time
+	connected time.Time
+}
+
+func (s *connectionStats) connect() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected = time.Now()
+}
+
+// replace keeps the statistics of a nats connection that is closed, reconnect counts it
+// as a reconnect when it is replaced after being suspended or by ReconnectNow.
+func (s *connectionStats) replace(conn *nats.Conn, reconnect bool) {
+	if s == nil || conn == nil {
+		return
+	}
+	stats := conn.Stats()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replaced.InMsgs += stats.InMsgs
+	s.replaced.OutMsgs += stats.OutMsgs
+	s.replaced.InBytes += stats.InBytes
+	s.replaced.OutBytes += stats.OutBytes
+	s.replaced.Reconnects += stats.Reconnects
+	if reconnect {
+		s.replaced.Reconnects++
+	}
+}
+
+// statsTicker returns the stats channel, or nil when Options.StatsInterval is not set.
+func (c *Connection) statsTicker() (tick <-chan time.Time, stop func()) {
+	if c.options.StatsInterval <= 0 {
+		return nil, func() {}
+	}
+	ticker := time.NewTicker(c.options.StatsInterval)
+	return ticker.C, ticker.Stop
+}
+
+// Statistics returns the message and byte counts and the number of reconnects.
+func (c *Connection) Statistics() (stats nats.Statistics) {
+	if c.stats != nil {
+		c.stats.mu.Lock()
+		stats = c.stats.replaced
+		c.stats.mu.Unlock()
+	}
+	if conn := c.natsConnection(); conn != nil {
+		current := conn.Stats()
+		stats.InMsgs += current.InMsgs
+		stats.OutMsgs += current.OutMsgs
+		stats.InBytes += current.InBytes
+		stats.OutBytes += current.OutBytes
+		stats.Reconnects += current.Reconnects
+	}
+	return
+}
+
+// ConnectedUrl returns the websocket url of the Proxy while connected, otherwise "".
+func (c *Connection) ConnectedUrl() string {
+	if !c.IsConnected() {
+		return ""
+	}
+	return c.wsUrl()
+}
+
+// StartTime returns when the Connection started.
+func (c *Connection) StartTime() time.Time {
+	if c.stats == nil {
+		return time.Time{}
+	}
+	c.stats.mu.Lock()
+	defer c.stats.mu.Unlock()
+	return c.stats.started
+}
+
+// Stats returns a snapshot of the statistics of the Connection.
+func (c *Connection) Stats() (stats Stats) {
+	stats = Stats{
+		Time:          time.Now(),
+		Statistics:    c.Statistics(),
+		ConnectedUrl:  c.ConnectedUrl(),
+		Subscriptions: c.Subscriptions(),
+		RelayDropped:  c.relay.droppedCount(),
+	}
+	stats.Latency, stats.AverageLatency = c.latency.get()
+	if c.stats != nil {
+		c.stats.mu.Lock()
+		stats.Started, stats.Connected = c.stats.started, c.stats.connected
+		c.stats.mu.Unlock()
+	}
+	if conn := c.natsConnection(); conn != nil && conn.IsConnected() {
+		stats.ServerName = conn.ConnectedServerName()
+	}
+	return
+}
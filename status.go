@@ -0,0 +1,95 @@
+package natsws
+
+import (
+	"github.com/maxence-charriere/go-app/v9/pkg/app"
+	"time"
+)
+
+// ConnectionStatus summarizes the state of a Connection for display.
+type ConnectionStatus string
+
+const StatusConnected ConnectionStatus = "connected"
+
+// StatusConnecting is reported until the Connection connects or fails for the first time.
+const StatusConnecting ConnectionStatus = "connecting"
+
+// StatusReconnecting is reported while the nats client is connecting or waiting to reconnect.
+const StatusReconnecting ConnectionStatus = "reconnecting"
+
+// StatusDisconnected is reported while the browser is offline or the Connection is suspended.
+const StatusDisconnected ConnectionStatus = "disconnected"
+
+// StatusError is reported when the most recent change of the Connection was an error.
+const StatusError ConnectionStatus = "error"
+
+// StatusClosed is reported after Connection.Close or Connection.Drain.
+const StatusClosed ConnectionStatus = "closed"
+
+// Status returns the ConnectionStatus of the Connection.
+func (c *Connection) Status() ConnectionStatus {
+	connected := c.IsConnected()
+	defer c.lock()()
+	switch {
+	case connected:
+		return StatusConnected
+	case c.changeReason == Closed:
+		return StatusClosed
+	case c.changeReason == Error && c.lastError != nil:
+		return StatusError
+	case c.changeReason == "":
+		return StatusConnecting
+	case !c.online || c.suspended:
+		return StatusDisconnected
+	default:
+		return StatusReconnecting
+	}
+}
+
+// StatusClass is the CSS class of the root element of the Status component. Elements within use
+// StatusClass-state, StatusClass-latency, StatusClass-error and StatusClass-reconnect and the root
+// element also has StatusClass-<ConnectionStatus>, for example natsws-status-connected.
+const StatusClass = "natsws-status"
+
+var _ app.Mounter = (*Status)(nil)
+
+// Status displays the ConnectionStatus, latency and last error of the Connection of the Component
+// with the same Name, with a button to reconnect without waiting for the reconnect delay.
+type Status struct {
+	app.Compo
+	// Name selects the Connection whose Component.Name equals it, empty selects the default Connection.
+	Name string
+	// Class is added to the classes of the root element.
+	Class string
+
+	conn Connection
+}
+
+func (s *Status) OnMount(ctx app.Context) {
+	ObserveName(ctx, s.Name, &s.conn).OnChange(s.Update)
+}
+
+func (s *Status) Render() app.UI {
+	status := s.conn.Status()
+
+	latency := "-"
+	if current := s.conn.Latency(); current > 0 {
+		latency = current.Round(time.Millisecond).String()
+	}
+
+	lastError := ""
+	if err := s.conn.LastError(); err != nil {
+		lastError = err.Error()
+	}
+
+	return app.Div().Class(StatusClass, StatusClass+"-"+string(status), s.Class).Body(
+		app.Span().Class(StatusClass+"-state").Text(status),
+		app.Span().Class(StatusClass+"-latency").Text(latency),
+		app.If(lastError != "",
+			app.Span().Class(StatusClass+"-error").Title(lastError).Text(lastError),
+		),
+		app.If(status != StatusConnected && status != StatusClosed,
+			app.Button().Class(StatusClass+"-reconnect").Text("reconnect now").
+				OnClick(func(ctx app.Context, e app.Event) { s.conn.ReconnectNow() }),
+		),
+	)
+}
@@ -0,0 +1,277 @@
+package natsws
+
+import (
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// subscriptions tracks the subscriptions made through a Connection.
+//
+// It is shared by all copies of the Connection that observers receive, so that
+// subscriptions are removed when the Connection stops and are recreated when
+// the Connection replaces its nats connection.
+type subscriptions struct {
+	mu      sync.Mutex
+	subs    []*subscription
+	nextId  int
+	traffic *traffic
+}
+
+type subscription struct {
+	id      string
+	subject string
+	queue   string
+	handler nats.MsgHandler
+	ch      chan *nats.Msg
+	sub     *nats.Subscription
+	// custom subscribes in place of handler or ch, for example to a JetStream consumer
+	custom func(conn *nats.Conn) (*nats.Subscription, error)
+	// closeCh closes ch when the subscription is removed, ch belongs to a Subscription
+	closeCh bool
+	// persist saves the state of a custom subscription, it is called when the
+	// subscription stops and when the page is hidden
+	persist func()
+
+	traffic  *traffic
+	received uint64
+	dropped  uint64
+
+	// mu guards relayed deliveries to ch against closing it
+	mu      sync.Mutex
+	removed bool
+}
+
+// SubscriptionInfo describes a subscription of a Connection.
+type SubscriptionInfo struct {
+	Subject string
+	Queue   string
+	// Received counts the messages delivered to the handler of the subscription, nats.go
+	// delivers the messages of ChanSubscribe to the channel directly and they are not counted.
+	Received uint64
+	// Pending is the number of messages waiting for the handler or in the channel of the subscription.
+	Pending int
+	// Dropped counts the messages dropped as a slow consumer when Pending reached its limits
+	// or the channel of ChanSubscribe was full.
+	Dropped uint64
+}
+
+func (s *subscription) subscribe(conn *nats.Conn) (err error) {
+	if s.custom != nil {
+		s.sub, err = s.custom(conn)
+	} else if s.ch != nil {
+		s.sub, err = conn.ChanQueueSubscribe(s.subject, s.queue, s.ch)
+	} else {
+		s.sub, err = conn.QueueSubscribe(s.subject, s.queue, s.handle)
+	}
+	return
+}
+
+// handle counts and taps a message before passing it to the handler.
+func (s *subscription) handle(msg *nats.Msg) {
+	atomic.AddUint64(&s.received, 1)
+	s.traffic.received(msg)
+	s.handler(msg)
+}
+
+// deliver handles a message that was relayed by the leader tab.
+func (s *subscription) deliver(msg *nats.Msg) {
+	if s.ch == nil {
+		s.handle(msg)
+		return
+	}
+	s.mu.Lock()
+	delivered := false
+	if !s.removed {
+		select {
+		case s.ch <- msg:
+			delivered = true
+		default:
+			// dropped as a slow consumer, like nats.go does for channel subscriptions
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+	s.mu.Unlock()
+	if delivered {
+		atomic.AddUint64(&s.received, 1)
+		s.traffic.received(msg)
+	}
+}
+
+// stop unsubscribes from the nats connection, no messages are delivered after it returns.
+func (s *subscription) stop() (err error) {
+	if s.sub != nil && s.sub.IsValid() {
+		err = s.sub.Unsubscribe()
+	}
+	if s.persist != nil {
+		s.persist()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.removed && s.closeCh {
+		close(s.ch)
+	}
+	s.removed = true
+	return
+}
+
+func (s *subscriptions) add(conn *nats.Conn, sub *subscription) (err error) {
+	sub.traffic = s.traffic
+	if err = sub.subscribe(conn); err != nil {
+		return
+	}
+	s.register(sub)
+	return
+}
+
+// addRelayed registers sub and asks the leader tab to subscribe on behalf of this tab.
+func (s *subscriptions) addRelayed(r *relay, sub *subscription) {
+	sub.traffic = s.traffic
+	s.register(sub)
+	r.subscribe(sub)
+}
+
+func (s *subscriptions) register(sub *subscription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextId++
+	sub.id = strconv.Itoa(s.nextId)
+	s.subs = append(s.subs, sub)
+}
+
+// persist saves the state of the custom subscriptions.
+func (s *subscriptions) persist() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sub := range s.subs {
+		if sub.persist != nil {
+			sub.persist()
+		}
+	}
+}
+
+// relay asks a new leader tab to subscribe on behalf of this tab.
+func (s *subscriptions) relay(r *relay) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sub := range s.subs {
+		r.subscribe(sub)
+	}
+}
+
+func (s *subscriptions) deliver(id string, msg *nats.Msg) {
+	s.mu.Lock()
+	var target *subscription
+	for _, sub := range s.subs {
+		if sub.id == id {
+			target = sub
+		}
+	}
+	s.mu.Unlock()
+	if target != nil {
+		target.deliver(msg)
+	}
+}
+
+// resubscribe recreates the subscriptions on conn, custom subscriptions need a
+// connected conn and are recreated by subscribeCustom.
+func (s *subscriptions) resubscribe(conn *nats.Conn) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sub := range s.subs {
+		if sub.custom != nil {
+			continue
+		}
+		if sub.sub != nil && sub.sub.IsValid() {
+			_ = sub.sub.Unsubscribe()
+		}
+		if subErr := sub.subscribe(conn); subErr != nil {
+			err = subErr
+		}
+	}
+	return
+}
+
+// subscribeCustom recreates the custom subscriptions that are not subscribed on conn, a
+// connected nats connection. s.mu is not held during the requests the subscriptions make,
+// the ones that fail are retried on the next call.
+func (s *subscriptions) subscribeCustom(conn *nats.Conn) (err error) {
+	s.mu.Lock()
+	var pending []*subscription
+	for _, sub := range s.subs {
+		if sub.custom != nil && (sub.sub == nil || !sub.sub.IsValid()) {
+			pending = append(pending, sub)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, sub := range pending {
+		natsSub, subErr := sub.custom(conn)
+		if subErr != nil {
+			err = fmt.Errorf("subscription %q: %w", sub.subject, subErr)
+			continue
+		}
+		s.mu.Lock()
+		sub.mu.Lock()
+		removed := sub.removed
+		if !removed {
+			sub.sub = natsSub
+		}
+		sub.mu.Unlock()
+		s.mu.Unlock()
+		if removed {
+			_ = natsSub.Unsubscribe()
+		}
+	}
+	return
+}
+
+// remove stops sub and forgets it, it is not recreated on a new nats connection.
+func (s *subscriptions) remove(sub *subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, registered := range s.subs {
+		if registered == sub {
+			s.subs = append(s.subs[:i:i], s.subs[i+1:]...)
+			break
+		}
+	}
+	return sub.stop()
+}
+
+func (s *subscriptions) info() (infos []SubscriptionInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sub := range s.subs {
+		info := SubscriptionInfo{
+			Subject:  sub.subject,
+			Queue:    sub.queue,
+			Received: atomic.LoadUint64(&sub.received),
+			Dropped:  atomic.LoadUint64(&sub.dropped),
+		}
+		if sub.ch != nil {
+			info.Pending = len(sub.ch)
+		}
+		if sub.sub != nil && sub.sub.IsValid() {
+			if sub.ch == nil {
+				info.Pending, _, _ = sub.sub.Pending()
+			}
+			if dropped, err := sub.sub.Dropped(); err == nil {
+				info.Dropped += uint64(dropped)
+			}
+		}
+		infos = append(infos, info)
+	}
+	return
+}
+
+func (s *subscriptions) unsubscribe() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sub := range s.subs {
+		_ = sub.stop()
+	}
+	s.subs = nil
+}
@@ -0,0 +1,30 @@
+package natsws
+
+import (
+	"github.com/nats-io/nats.go"
+	"testing"
+)
+
+func TestSubscriptionCounts(t *testing.T) {
+	var tapped []TrafficEvent
+	subs := &subscriptions{traffic: &traffic{}}
+	remove := subs.traffic.tap(func(event TrafficEvent) { tapped = append(tapped, event) })
+
+	sub := &subscription{subject: "counts", ch: make(chan *nats.Msg, 1)}
+	sub.traffic = subs.traffic
+	subs.register(sub)
+	for i := 0; i < 3; i++ {
+		subs.deliver(sub.id, &nats.Msg{Subject: "counts"})
+	}
+	remove()
+	subs.deliver(sub.id, &nats.Msg{Subject: "counts"})
+
+	// the channel holds the first message, the others are dropped
+	infos := subs.info()
+	if len(infos) != 1 || infos[0].Received != 1 || infos[0].Pending != 1 || infos[0].Dropped != 3 {
+		t.Fatalf("unexpected subscription info %+v", infos)
+	}
+	if len(tapped) != 1 || tapped[0].Kind != TrafficMessage || tapped[0].Outgoing {
+		t.Fatalf("unexpected traffic %+v", tapped)
+	}
+}
@@ -0,0 +1,203 @@
+package natsws
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceParentHeader is the W3C Trace Context header used by OpenTelemetry propagators.
+const TraceParentHeader = "traceparent"
+
+// SpanContext identifies a span within a trace.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// TraceParent formats the SpanContext as a traceparent header value.
+func (sc SpanContext) TraceParent() string {
+	flags := 0
+	if sc.Sampled {
+		flags = 1
+	}
+	return fmt.Sprintf("00-%x-%x-%02x", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceParent parses a traceparent header value.
+func ParseTraceParent(value string) (sc SpanContext, ok bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid()
+}
+
+// InjectTrace sets the traceparent header of msg.
+func InjectTrace(msg *nats.Msg, sc SpanContext) {
+	if !sc.IsValid() {
+		return
+	}
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+	msg.Header.Set(TraceParentHeader, sc.TraceParent())
+}
+
+// ExtractTrace returns the SpanContext from the traceparent header of msg.
+func ExtractTrace(msg *nats.Msg) (sc SpanContext, ok bool) {
+	if msg == nil || msg.Header == nil {
+		return
+	}
+	return ParseTraceParent(msg.Header.Get(TraceParentHeader))
+}
+
+// Span is a timed operation within a trace.
+type Span struct {
+	Name        string
+	SpanContext SpanContext
+	Parent      SpanContext
+	Start       time.Time
+	End         time.Time
+	Attributes  map[string]string
+	Err         error
+
+	tracer *Tracer
+	mu     sync.Mutex
+}
+
+// SetAttribute records a key value pair on the span, it is safe to call on a nil span.
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Attributes[key] = value
+}
+
+// Finish ends the span with an optional error and hands it to the exporter.
+// It is safe to call on a nil span.
+func (s *Span) Finish(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.End = time.Now()
+	s.Err = err
+	s.mu.Unlock()
+	if s.tracer.Exporter != nil {
+		s.tracer.Exporter.ExportSpan(s)
+	}
+}
+
+// SpanExporter receives finished spans.
+//
+// Implementations can adapt spans to an OpenTelemetry exporter, the
+// TraceID and SpanID use the same representation as OpenTelemetry.
+type SpanExporter interface {
+	ExportSpan(span *Span)
+}
+
+// Tracer creates spans for Connection, Proxy and backend subscribers.
+// All methods are safe to call on a nil Tracer and return nil spans.
+type Tracer struct {
+	Exporter SpanExporter
+}
+
+// Start starts a span that is a child of parent and keeps its sampled flag,
+// or a new sampled trace if parent is not valid.
+func (t *Tracer) Start(parent SpanContext, name string) *Span {
+	if t == nil {
+		return nil
+	}
+	span := &Span{Name: name, Parent: parent, Start: time.Now(), Attributes: map[string]string{}, tracer: t}
+	if parent.IsValid() {
+		span.SpanContext.TraceID = parent.TraceID
+		span.SpanContext.Sampled = parent.Sampled
+	} else {
+		_, _ = rand.Read(span.SpanContext.TraceID[:])
+		span.SpanContext.Sampled = true
+	}
+	_, _ = rand.Read(span.SpanContext.SpanID[:])
+	return span
+}
+
+// StartSend starts a span for an outgoing message and injects it into the message headers.
+// The span continues the trace of a traceparent header the caller already set on msg.
+func (t *Tracer) StartSend(name string, msg *nats.Msg) *Span {
+	parent, _ := ExtractTrace(msg)
+	span := t.Start(parent, name)
+	if span != nil {
+		span.SetAttribute("subject", msg.Subject)
+		InjectTrace(msg, span.SpanContext)
+	}
+	return span
+}
+
+// StartReceive starts a span for a received message that continues the trace of the sender.
+func (t *Tracer) StartReceive(name string, msg *nats.Msg) *Span {
+	parent, _ := ExtractTrace(msg)
+	span := t.Start(parent, name)
+	span.SetAttribute("subject", msg.Subject)
+	return span
+}
+
+// tracedHandler wraps cb with a receive span when t is not nil.
+func (t *Tracer) tracedHandler(cb nats.MsgHandler) nats.MsgHandler {
+	if t == nil {
+		return cb
+	}
+	return func(msg *nats.Msg) {
+		span := t.StartReceive("receive "+msg.Subject, msg)
+		defer span.Finish(nil)
+		cb(msg)
+	}
+}
+
+var _ SpanExporter = (*MemoryExporter)(nil)
+
+// MemoryExporter keeps finished spans in memory for use in tests.
+type MemoryExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+func (e *MemoryExporter) ExportSpan(span *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+// Spans returns the finished spans in the order they ended.
+func (e *MemoryExporter) Spans() []*Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*Span{}, e.spans...)
+}
+
+func (e *MemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
@@ -0,0 +1,79 @@
+package natsws
+
+import (
+	"github.com/nats-io/nats.go"
+	"strconv"
+	"testing"
+)
+
+func TestTracePropagation(t *testing.T) {
+	exporter := &MemoryExporter{}
+	tracer := &Tracer{Exporter: exporter}
+
+	msg := nats.NewMsg("subject")
+	send := tracer.StartSend("publish subject", msg)
+	send.Finish(nil)
+
+	parsed, ok := ParseTraceParent(msg.Header.Get(TraceParentHeader))
+	if !ok || parsed != send.SpanContext {
+		t.Fatalf("traceparent %q did not round trip", msg.Header.Get(TraceParentHeader))
+	}
+
+	receive := tracer.StartReceive("receive subject", msg)
+	receive.Finish(nil)
+
+	spans := exporter.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	if spans[1].SpanContext.TraceID != spans[0].SpanContext.TraceID || spans[1].Parent != spans[0].SpanContext {
+		t.Fatal("receive span is not a child of the send span")
+	}
+
+	var nilTracer *Tracer
+	nilTracer.StartSend("publish subject", nats.NewMsg("subject")).Finish(nil)
+}
+
+func TestTraceSendContinuesCallerTrace(t *testing.T) {
+	tracer := &Tracer{}
+
+	caller := tracer.Start(SpanContext{}, "caller")
+	caller.SpanContext.Sampled = false
+	msg := nats.NewMsg("subject")
+	InjectTrace(msg, caller.SpanContext)
+
+	send := tracer.StartSend("publish subject", msg)
+	if send.Parent != caller.SpanContext || send.SpanContext.TraceID != caller.SpanContext.TraceID {
+		t.Fatalf("send span %+v did not continue the caller trace %+v", send.SpanContext, caller.SpanContext)
+	}
+	if send.SpanContext.Sampled {
+		t.Fatal("send span did not keep the unsampled flag of the caller")
+	}
+	if parsed, _ := ExtractTrace(msg); parsed != send.SpanContext {
+		t.Fatalf("traceparent %q is not the send span", msg.Header.Get(TraceParentHeader))
+	}
+}
+
+func TestProxyTraceFrame(t *testing.T) {
+	exporter := &MemoryExporter{}
+	proxy := &Proxy{Tracer: &Tracer{Exporter: exporter}, TraceMessages: true}
+	session := &proxySession{clientId: "client", span: proxy.Tracer.Start(SpanContext{}, "session")}
+
+	parent := proxy.Tracer.Start(SpanContext{}, "publish")
+	header := "NATS/1.0\r\n" + TraceParentHeader + ": " + parent.SpanContext.TraceParent() + "\r\n\r\n"
+	frame := "PING\r\nHPUB traced " + strconv.Itoa(len(header)) + " " + strconv.Itoa(len(header)+5) + "\r\n" + header + "hello\r\n" +
+		"PUB untraced 5\r\nhello\r\n"
+
+	proxy.traceFrame(session, ClientToBackend, []byte(frame))
+
+	spans := exporter.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	if spans[0].Parent != parent.SpanContext || spans[0].Attributes["subject"] != "traced" {
+		t.Fatalf("HPUB span did not continue the message trace %+v", spans[0])
+	}
+	if spans[1].Parent != session.span.SpanContext || spans[1].Attributes["size"] != "5" {
+		t.Fatalf("PUB span did not continue the session trace %+v", spans[1])
+	}
+}
@@ -0,0 +1,98 @@
+package natsws
+
+import (
+	"github.com/nats-io/nats.go"
+	"sync"
+	"time"
+)
+
+// Kinds of TrafficEvent.
+const (
+	TrafficPublish  = "publish"
+	TrafficRequest  = "request"
+	TrafficResponse = "response"
+	TrafficMessage  = "message"
+)
+
+// TrafficEvent is a message published or received by a Connection, see Connection.Tap.
+type TrafficEvent struct {
+	Time time.Time
+	Kind string
+	// Outgoing is true for TrafficPublish and TrafficRequest.
+	Outgoing bool
+	Subject  string
+	Reply    string
+	Header   nats.Header
+	Data     []byte
+	// Err is the error of a publish or request.
+	Err error
+}
+
+// traffic passes TrafficEvents to the taps of a Connection, it is shared by all copies of the Connection.
+type traffic struct {
+	mu     sync.Mutex
+	taps   map[int]func(event TrafficEvent)
+	nextId int
+}
+
+func (t *traffic) tap(fn func(event TrafficEvent)) (remove func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.taps == nil {
+		t.taps = map[int]func(event TrafficEvent){}
+	}
+	t.nextId++
+	id := t.nextId
+	t.taps[id] = fn
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		delete(t.taps, id)
+	}
+}
+
+func (t *traffic) emit(kind string, msg *nats.Msg, err error) {
+	if t == nil || msg == nil {
+		return
+	}
+	t.mu.Lock()
+	taps := make([]func(event TrafficEvent), 0, len(t.taps))
+	for _, fn := range t.taps {
+		taps = append(taps, fn)
+	}
+	t.mu.Unlock()
+	if len(taps) == 0 {
+		return
+	}
+
+	event := TrafficEvent{
+		Time: time.Now(), Kind: kind, Outgoing: kind == TrafficPublish || kind == TrafficRequest,
+		Subject: msg.Subject, Reply: msg.Reply, Header: msg.Header, Data: msg.Data, Err: err,
+	}
+	for _, fn := range taps {
+		fn(event)
+	}
+}
+
+func (t *traffic) received(msg *nats.Msg) {
+	t.emit(TrafficMessage, msg, nil)
+}
+
+// Tap calls fn for each message published, requested or received through subscriptions of the
+// Connection until remove is called. fn is called on nats.go goroutines, use ctx.Dispatch to
+// update components. Messages nats.go delivers to the channel of ChanSubscribe, JetStream
+// consumers and the Object Store are not tapped.
+func (c *Connection) Tap(fn func(event TrafficEvent)) (remove func()) {
+	if c.traffic == nil {
+		return func() {}
+	}
+	return c.traffic.tap(fn)
+}
+
+// Subscriptions describes the subscriptions of the Connection.
+func (c *Connection) Subscriptions() []SubscriptionInfo {
+	if c.subs == nil {
+		return nil
+	}
+	return c.subs.info()
+}